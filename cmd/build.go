@@ -1,72 +1,59 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
 
 	"github.com/mattwiller/hawthorn/internal"
 )
 
-var setup = []string{
-	`CREATE TABLE IF NOT EXISTS "CodeSystem" (
-		id			INTEGER	PRIMARY KEY AUTOINCREMENT,
-		_id			TEXT	NOT NULL,
-		title		TEXT	NOT NULL,
-		url			TEXT	NOT NULL,
-		json		TEXT	NOT NULL
-	)`,
-	`CREATE TABLE IF NOT EXISTS "ValueSet" (
-		id			INTEGER	PRIMARY KEY AUTOINCREMENT,
-		_id			TEXT	NOT NULL,
-		url			TEXT	NOT NULL,
-		json		TEXT	NOT NULL
-	)`,
-	`CREATE TABLE IF NOT EXISTS "Coding" (
-		id			INTEGER	PRIMARY KEY AUTOINCREMENT,
-		system		INTEGER	NOT NULL, -- reference to "CodeSystem".id
-		code		TEXT				NOT NULL,
-		display		TEXT
-	)`,
-	`CREATE UNIQUE INDEX IF NOT EXISTS "Coding_system_code_idx" ON "Coding" (system, code)`,
-	`CREATE VIRTUAL TABLE IF NOT EXISTS "Coding_fts_idx" USING fts5(display, tokenize = 'porter', content='Coding', content_rowid='id')`,
-	// Triggers to keep the FTS index up to date.
-	`CREATE TRIGGER "Coding_postinsert" AFTER INSERT ON "Coding" BEGIN
-		INSERT INTO "Coding_fts_idx" (rowid, display) VALUES (new.id, new.display);
-	END`,
-	`CREATE TRIGGER "Coding_postdelete" AFTER DELETE ON "Coding" BEGIN
-		INSERT INTO "Coding_fts_idx" ("Coding_fts_idx", rowid, display) VALUES ('delete', old.id, old.display);
-	END`,
-	`CREATE TRIGGER "Coding_postupdate" AFTER UPDATE ON "Coding" BEGIN
-		INSERT INTO "Coding_fts_idx" ("Coding_fts_idx", rowid, display) VALUES ('delete', old.id, old.display);
-		INSERT INTO "Coding_fts_idx" (rowid, display) VALUES (new.id, new.display);
-	END`,
-
-	`CREATE TABLE IF NOT EXISTS "CodeSystem_Property" (
-		id			INTEGER	PRIMARY KEY AUTOINCREMENT,
-		system		INTEGER	NOT NULL,
-		code		TEXT	NOT NULL,
-		type		TEXT	NOT NULL,
-		uri			TEXT,
-		description TEXT
-	)`,
-
-	`CREATE TABLE IF NOT EXISTS "Coding_Property" (
-		coding		INTEGER	NOT NULL, -- reference to "Coding".id
-		property	INTEGER	NOT NULL, -- reference to "CodeSystem_Property".id
-		target		INTEGER, -- reference to "Coding".id, for relationship properties
-		value		TEXT -- value could be string | integer | boolean | dateTime
-	)`,
-	`CREATE INDEX IF NOT EXISTS "Coding_Property_idx" ON "Coding_Property" (coding, property)`,
-	`CREATE INDEX IF NOT EXISTS "Coding_Property_relationship_idx" ON "Coding_Property" (coding, target, property)
-		WHERE target IS NOT NULL`,
-
-	`CREATE TABLE IF NOT EXISTS "ValueSet_Membership" (
-		"valueSet"	INTEGER, -- reference to "ValueSet".id
-		coding		INTEGER, -- reference to "Coding".id
-		PRIMARY KEY ("valueSet", coding)
-	) WITHOUT ROWID`,
+// Statements run before a bulk load to speed up writes, at the expense of the database being corrupted if the build is
+// interrupted, in which case it must be restarted from scratch anyway.
+var bulkSetup = []string{
+	`PRAGMA journal_mode = OFF`,
+	`PRAGMA synchronous = OFF`,
 }
 
 func main() {
+	configFile := flag.String("config", "", "Build config `file` (YAML or JSON) naming the UMLS release and sources to load")
+	release := flag.String("release", "", "UMLS release archive or extracted directory to load, overriding the config")
+	valueSets := flag.String("valuesets", "", "Directory or NDJSON file of FHIR ValueSet resources to load, overriding the config")
+	languages := flag.String("languages", "", "Comma-separated codes of languages to load designations for in addition to English, e.g. es,fr, overriding the config")
+	bulk := flag.Bool("bulk", false, "Load in bulk mode, with indexes created after loading and journaling disabled, overriding the config")
+	flag.Parse()
+
+	cfg := internal.DefaultBuildConfig()
+	if *configFile != "" {
+		var err error
+		if cfg, err = internal.ReadBuildConfig(*configFile); err != nil {
+			panic(err)
+		}
+	}
+	// Paths given as flags are relative to the working directory rather than the config file
+	if *release != "" {
+		cfg.Release, _ = filepath.Abs(*release)
+	}
+	if *valueSets != "" {
+		cfg.ValueSets, _ = filepath.Abs(*valueSets)
+	}
+	if *languages != "" {
+		cfg.Languages = strings.Split(*languages, ",")
+	}
+	if *bulk {
+		cfg.Bulk = true
+	}
+	// Any arguments are the individual release files to load
+	if flag.NArg() > 0 {
+		cfg.Files = make([]string, flag.NArg())
+		for i, file := range flag.Args() {
+			cfg.Files[i], _ = filepath.Abs(file)
+		}
+	}
+
 	db, err := internal.NewDB("umls.db")
 	if err != nil {
 		panic(err)
@@ -74,16 +61,80 @@ func main() {
 	defer db.Close()
 
 	fmt.Printf("Connected to database, running setup statements...\n")
-	for _, stmt := range setup {
-		_, err := db.Query(stmt)
-		if err != nil {
-			panic(fmt.Errorf(`error executing setup statement: %w`, err))
+	if cfg.Bulk {
+		runStatements(db, bulkSetup)
+		runStatements(db, internal.Schema)
+		cfg.AfterLoad = func(db *internal.DB) error {
+			fmt.Printf("Creating indexes...\n")
+			runStatements(db, internal.Indexes)
+			fmt.Println("✅")
+			fmt.Printf("Building text search index...")
+			if _, err := db.Query(`INSERT INTO "Coding_fts_idx" ("Coding_fts_idx") VALUES ('rebuild')`); err != nil {
+				return err
+			}
+			fmt.Print("✅\n\n")
+			return nil
 		}
-		fmt.Print(".")
+	} else {
+		runStatements(db, internal.Schema)
+		runStatements(db, internal.Indexes)
 	}
 	fmt.Println("✅")
 
-	if err := internal.LoadUMLS(db); err != nil {
+	if err := internal.LoadUMLS(db, cfg); err != nil {
 		panic(err)
 	}
+
+	if cfg.ValueSets != "" {
+		if err := internal.LoadValueSets(db, cfg.Path(cfg.ValueSets)); err != nil {
+			panic(fmt.Errorf("error loading value sets: %w", err))
+		}
+	}
+
+	if err := internal.SetMetadata(db, "buildTime", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		panic(err)
+	}
+	if commit := hawthornCommit(); commit != "" {
+		if err := internal.SetMetadata(db, "hawthornCommit", commit); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Git commit of Hawthorn the build was run from, set with -ldflags "-X main.commit=..." since the commit is not stamped
+// into the binary when running the build with go run.
+var commit string
+
+func hawthornCommit() string {
+	if commit != "" {
+		return commit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	// Uncommitted changes mean the commit alone does not identify the code the build was run from
+	if revision != "" && modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+func runStatements(db *internal.DB, statements []string) {
+	for _, stmt := range statements {
+		_, err := db.Query(stmt)
+		if err != nil {
+			panic(fmt.Errorf(`error executing setup statement: %w`, err))
+		}
+		fmt.Print(".")
+	}
 }
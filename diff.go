@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+// Columns of the CSV diff output, in the order of the Change fields.
+var changeColumns = []string{"type", "system", "code", "property", "valueSet", "old", "new"}
+
+// Compares two databases, e.g. before rolling out one built from a new UMLS release: each change is written to the
+// output as NDJSON or CSV, and a summary of the changes to each code system is printed to stderr.
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("hawthorn diff", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: hawthorn diff [flags] old.db new.db")
+		flags.PrintDefaults()
+	}
+	out := flags.String("out", "", "Output `file` for the list of changes, written to stdout if not set")
+	format := flags.String("format", "", "Output `format` of the list of changes: ndjson or csv, by default from the output file extension or else ndjson")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 2 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	oldPath, newPath := flags.Arg(0), flags.Arg(1)
+
+	if *format == "" {
+		*format = "ndjson"
+		if filepath.Ext(*out) == ".csv" {
+			*format = "csv"
+		}
+	}
+	var file *os.File
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		var err error
+		if file, err = os.Create(*out); err != nil {
+			return err
+		}
+		// Only closes the file on error, since otherwise it is closed explicitly to check for write errors
+		defer file.Close()
+		w = file
+	}
+	write, flush, err := changeWriter(w, *format)
+	if err != nil {
+		return err
+	}
+
+	// The databases are only read, so missing files must not be created by opening them
+	for _, path := range []string{oldPath, newPath} {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+	db, err := internal.OpenReadOnly(newPath, 1)
+	if err != nil {
+		return fmt.Errorf("error opening database file %s: %w", newPath, err)
+	}
+	defer db.Close()
+
+	summaries, err := internal.Diff(db, oldPath, write)
+	if err != nil {
+		return err
+	}
+	// Write errors (e.g. a full disk) must fail the command, rather than leave a truncated list of changes
+	if err := flush(); err != nil {
+		return fmt.Errorf("error writing changes: %w", err)
+	}
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("error writing changes: %w", err)
+		}
+	}
+	return printDiffSummary(os.Stderr, summaries)
+}
+
+// Returns functions which write each change to w in the given format, and flush any buffered output once all changes
+// have been written.
+func changeWriter(w io.Writer, format string) (func(change internal.Change) error, func() error, error) {
+	switch format {
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		write := func(change internal.Change) error { return encoder.Encode(change) }
+		return write, func() error { return nil }, nil
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write(changeColumns); err != nil {
+			return nil, nil, err
+		}
+		write := func(change internal.Change) error {
+			return writer.Write([]string{change.Type, change.System, change.Code, change.Property, change.ValueSet, change.Old, change.New})
+		}
+		flush := func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+		return write, flush, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported diff format %s", format)
+}
+
+// Prints a table of the number of changes of each type to each code system.
+func printDiffSummary(w io.Writer, summaries []*internal.SystemSummary) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Code system\tStatus\tOld version\tNew version\tAdded\tRetired\tDisplay\tProperties\tValue sets")
+	for _, s := range summaries {
+		oldVersion, newVersion := orNone(s.OldVersion), orNone(s.NewVersion)
+		c := s.Changes
+		switch {
+		case s.Added:
+			fmt.Fprintf(table, "%s\tadded\t%s\t%s\t-\t-\t-\t-\t-\n", s.System, oldVersion, newVersion)
+		case s.Removed:
+			fmt.Fprintf(table, "%s\tremoved\t%s\t%s\t-\t-\t-\t-\t-\n", s.System, oldVersion, newVersion)
+		default:
+			status := "changed"
+			if len(c) == 0 {
+				status = "unchanged"
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\n", s.System, status, oldVersion, newVersion,
+				c[internal.ChangeCodeAdded], c[internal.ChangeCodeRetired], c[internal.ChangeDisplay],
+				c[internal.ChangeProperty]+c[internal.ChangePropertyAdded]+c[internal.ChangePropertyRemoved],
+				c[internal.ChangeMemberAdded]+c[internal.ChangeMemberRemoved]+c[internal.ChangeValueSetAdded]+c[internal.ChangeValueSetRemoved])
+		}
+	}
+	return table.Flush()
+}
+
+func orNone(version string) string {
+	if version == "" {
+		return "-"
+	}
+	return version
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/mattwiller/hawthorn/internal/testdb"
+	"github.com/stretchr/testify/require"
+)
+
+func copyOldRelease(t *testing.T) string {
+	return testdb.Copy(t, "umls.db", "umls-old.db",
+		append(testdb.EarlierRelease, `DELETE FROM "CodeSystem" WHERE url = 'http://hl7.org/fhir/sid/cvx'`)...)
+}
+
+func TestRunDiffNDJSON(t *testing.T) {
+	require := require.New(t)
+
+	out := filepath.Join(t.TempDir(), "changes.ndjson")
+	require.NoError(runDiff([]string{"-out", out, copyOldRelease(t), "umls.db"}))
+
+	contents, err := os.ReadFile(out)
+	require.NoError(err)
+	var changes []internal.Change
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		var change internal.Change
+		require.NoError(json.Unmarshal([]byte(line), &change))
+		changes = append(changes, change)
+	}
+	require.Contains(changes, internal.Change{Type: internal.ChangeSystemAdded, System: "http://hl7.org/fhir/sid/cvx", New: "20230816"})
+	require.Contains(changes, internal.Change{Type: internal.ChangeDisplay, System: "http://loinc.org", Code: "79741-5",
+		Old: "Brain MRI findings related to eye", New: "Eye-related brain MRI findings"})
+}
+
+func TestRunDiffCSV(t *testing.T) {
+	require := require.New(t)
+
+	out := filepath.Join(t.TempDir(), "changes.csv")
+	require.NoError(runDiff([]string{"-out", out, copyOldRelease(t), "umls.db"}))
+
+	file, err := os.Open(out)
+	require.NoError(err)
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	require.NoError(err)
+	require.Equal(changeColumns, rows[0])
+	require.Contains(rows, []string{internal.ChangeCodeAdded, "http://loinc.org", "2345-7", "", "", "",
+		"Glucose [Mass/volume] in Serum or Plasma"})
+}
+
+func TestRunDiffWriteError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full is not available")
+	}
+
+	for _, format := range []string{"ndjson", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			err := runDiff([]string{"-out", "/dev/full", "-format", format, copyOldRelease(t), "umls.db"})
+			require.ErrorContains(t, err, "no space left on device")
+		})
+	}
+}
+
+func TestPrintDiffSummary(t *testing.T) {
+	require := require.New(t)
+
+	var output bytes.Buffer
+	require.NoError(printDiffSummary(&output, []*internal.SystemSummary{
+		{System: "http://hl7.org/fhir/sid/cvx", NewVersion: "20230816", Added: true},
+		{System: "http://loinc.org", OldVersion: "2.74", NewVersion: "2.76", Changes: map[string]int{
+			internal.ChangeCodeAdded: 1, internal.ChangeDisplay: 1, internal.ChangeMemberAdded: 3,
+		}},
+		{System: "http://snomed.info/sct", Changes: map[string]int{}},
+	}))
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(lines, 4)
+	for _, line := range lines[1:] {
+		require.Len(strings.Fields(line), len(strings.Fields(lines[0]))-4, "each row has a cell for every column: %q", line)
+	}
+	require.Equal([]string{"http://loinc.org", "changed", "2.74", "2.76", "1", "0", "1", "0", "3"}, strings.Fields(lines[2]))
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Number of rows written in each transaction while loading release files.
+const defaultBatchSize = 500
+
+// Number of rows written in each transaction in bulk mode, trading progress saved on failure for fewer commits.
+const bulkBatchSize = 100_000
+
+// Number of rows parsed together by each parsing goroutine.
+const parseChunkSize = 4096
+
+// Parses the rows of a release file in parallel goroutines, passing each parsed row to write from a single goroutine
+// since the database allows only one writer. Rows are written in their original order in the file, so the build is
+// deterministic, and rows which parse returns false for are skipped. At most 2×GOMAXPROCS chunks are in flight at
+// once, so a slow chunk holds back reading the file rather than buffering the rest of it in memory.
+func parseRows[T any](file io.Reader, parse func(row []byte) (T, bool), write func(row T) error) error {
+	type chunk[R any] struct {
+		seq  int
+		rows []R
+	}
+	workers := runtime.GOMAXPROCS(0)
+	done := make(chan struct{})
+	defer close(done)
+	// Each chunk takes a slot when it is read, which is freed once it has been written
+	inFlight := make(chan struct{}, 2*workers)
+
+	// Split the file into chunks of rows, copied since the scanner reuses its buffer
+	lines := make(chan chunk[[]byte], workers)
+	var scanErr error
+	go func() {
+		defer close(lines)
+		scan := bufio.NewScanner(file)
+		next := chunk[[]byte]{rows: make([][]byte, 0, parseChunkSize)}
+		send := func() bool {
+			select {
+			case inFlight <- struct{}{}:
+			case <-done:
+				return false
+			}
+			select {
+			case lines <- next:
+				next = chunk[[]byte]{seq: next.seq + 1, rows: make([][]byte, 0, parseChunkSize)}
+				return true
+			case <-done:
+				return false
+			}
+		}
+		for scan.Scan() {
+			next.rows = append(next.rows, bytes.Clone(scan.Bytes()))
+			if len(next.rows) == parseChunkSize && !send() {
+				return
+			}
+		}
+		scanErr = scan.Err()
+		if len(next.rows) > 0 {
+			send()
+		}
+	}()
+
+	parsed := make(chan chunk[T], workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range lines {
+				rows := make([]T, 0, len(c.rows))
+				for _, line := range c.rows {
+					if row, ok := parse(line); ok {
+						rows = append(rows, row)
+					}
+				}
+				select {
+				case parsed <- chunk[T]{seq: c.seq, rows: rows}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(parsed)
+	}()
+
+	// Chunks may finish parsing out of order, so hold each until all chunks before it have been written
+	pending := make(map[int][]T, workers)
+	next := 0
+	for c := range parsed {
+		pending[c.seq] = c.rows
+		for rows, ok := pending[next]; ok; rows, ok = pending[next] {
+			delete(pending, next)
+			next++
+			for _, row := range rows {
+				if err := write(row); err != nil {
+					return err
+				}
+			}
+			<-inFlight
+		}
+	}
+	return scanErr
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Generates an endless file of numbered rows, counting the rows read.
+type rowGenerator struct {
+	rows    atomic.Int64
+	pending []byte
+}
+
+func (g *rowGenerator) Read(p []byte) (int, error) {
+	if len(g.pending) == 0 {
+		g.pending = []byte(fmt.Sprintf("%d\n", g.rows.Add(1)-1))
+	}
+	n := copy(p, g.pending)
+	g.pending = g.pending[n:]
+	return n, nil
+}
+
+func parseNumber(row []byte) (int, bool) {
+	n, err := strconv.Atoi(string(row))
+	return n, err == nil
+}
+
+func TestParseRowsOrder(t *testing.T) {
+	require := require.New(t)
+
+	// Several chunks, with the first parsed slowest so they finish out of order
+	total := 3*parseChunkSize + 17
+	var file strings.Builder
+	for i := 0; i < total; i++ {
+		if i%10 == 5 {
+			file.WriteString("skipped\n")
+		} else {
+			fmt.Fprintf(&file, "%d\n", i)
+		}
+	}
+	parse := func(row []byte) (int, bool) {
+		if string(row) == "0" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return parseNumber(row)
+	}
+
+	var written []int
+	err := parseRows(strings.NewReader(file.String()), parse, func(n int) error {
+		written = append(written, n)
+		return nil
+	})
+	require.NoError(err)
+
+	var expected []int
+	for i := 0; i < total; i++ {
+		if i%10 != 5 {
+			expected = append(expected, i)
+		}
+	}
+	require.Equal(expected, written)
+}
+
+func TestParseRowsWriteError(t *testing.T) {
+	require := require.New(t)
+
+	// Stopping early must not wait for the rest of the file, which here never ends
+	failed := errors.New("write failed")
+	n := 0
+	err := parseRows(&rowGenerator{}, parseNumber, func(row int) error {
+		require.Equal(n, row)
+		n++
+		if n == parseChunkSize+1 {
+			return failed
+		}
+		return nil
+	})
+	require.ErrorIs(err, failed)
+	require.Equal(parseChunkSize+1, n)
+}
+
+type failingReader struct {
+	io.Reader
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+func TestParseRowsReadError(t *testing.T) {
+	require := require.New(t)
+
+	failed := errors.New("read failed")
+	file := &failingReader{Reader: strings.NewReader(strings.Repeat("1\n", 2*parseChunkSize+1)), err: failed}
+	n := 0
+	err := parseRows(file, parseNumber, func(row int) error {
+		n++
+		return nil
+	})
+	require.ErrorIs(err, failed)
+	require.Equal(2*parseChunkSize+1, n)
+}
+
+func TestParseRowsBackPressure(t *testing.T) {
+	require := require.New(t)
+
+	// While the first chunk is stalled, only a bounded number of later chunks are read ahead by the other workers
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	file := &rowGenerator{}
+	stalled := make(chan struct{})
+	parse := func(row []byte) (int, bool) {
+		if string(row) == "0" {
+			<-stalled
+		}
+		return parseNumber(row)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		// Chunks in flight, plus the one being filled by the scanner and the rows in its buffer
+		limit := int64((2*runtime.GOMAXPROCS(0)+1)*parseChunkSize + bufio.MaxScanTokenSize)
+		if read := file.rows.Load(); read > limit {
+			t.Errorf("read %d rows while the first chunk was stalled, expected at most %d", read, limit)
+		}
+		close(stalled)
+	}()
+
+	done := errors.New("done")
+	err := parseRows(file, parse, func(row int) error {
+		return done
+	})
+	require.ErrorIs(err, done)
+}
@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// A row of MRMAP.RRF, mapping a source code to a target expression within a map set.
+// @see https://www.ncbi.nlm.nih.gov/books/NBK9685/table/ch03.T.mappings_file_mrmap_rrf/
+type Mapping struct {
+	// Unique identifier for the UMLS concept which represents the whole map set.
+	MAPSETCUI string
+	// Source abbreviation (SAB) for the provider of the map set.
+	MAPSETSAB string
+	// Map subset identifier, used to group mappings (e.g. the map group of a SNOMED CT rule-based map).
+	MAPSUBSETID string
+	// Order in which mappings in a subset should be applied (e.g. the map priority of a SNOMED CT rule-based map).
+	MAPRANK int
+	// Unique identifier for the mapping.
+	MAPID string
+	// Source asserted identifier for the mapping.
+	MAPSID string
+	// Entry term in the source vocabulary.
+	FROMEXPR string
+	// Type of the source expression, e.g. SCUI or CODE.
+	FROMTYPE string
+	// Relationship of the target to the source.
+	REL string
+	// Additional relationship label.
+	RELA string
+	// Target of the mapping.
+	TOEXPR string
+	// Type of the target expression.
+	TOTYPE string
+	// Machine processable rule applicable to this mapping.
+	MAPRULE string
+	// Human readable restriction or advice applicable to this mapping.
+	MAPRES string
+	// Type of mapping.
+	MAPTYPE string
+}
+
+func ParseMapping(row []byte) Mapping {
+	mapping := Mapping{}
+	fields := bytes.Split(row, pipeDelimiter)
+	for n, value := range fields {
+		switch n {
+		case 0:
+			mapping.MAPSETCUI = string(value)
+		case 1:
+			mapping.MAPSETSAB = string(value)
+		case 2:
+			mapping.MAPSUBSETID = string(value)
+		case 3:
+			if len(value) > 0 {
+				n, _ := strconv.ParseInt(string(value), 10, 0)
+				mapping.MAPRANK = int(n)
+			}
+		case 4:
+			mapping.MAPID = string(value)
+		case 5:
+			mapping.MAPSID = string(value)
+		case 8:
+			mapping.FROMEXPR = string(value)
+		case 9:
+			mapping.FROMTYPE = string(value)
+		case 12:
+			mapping.REL = string(value)
+		case 13:
+			mapping.RELA = string(value)
+		case 16:
+			mapping.TOEXPR = string(value)
+		case 17:
+			mapping.TOTYPE = string(value)
+		case 20:
+			mapping.MAPRULE = string(value)
+		case 21:
+			mapping.MAPRES = string(value)
+		case 22:
+			mapping.MAPTYPE = string(value)
+		}
+	}
+	return mapping
+}
+
+// Attributes of map sets, which are stored in MRSAT.RRF on the concept representing each map set, keyed by the CUI of
+// the map set and then the attribute name.
+// @see https://www.nlm.nih.gov/research/umls/knowledge_sources/metathesaurus/release/attribute_names.html
+type MapSets map[string]map[string]string
+
+var mapSetAttributes = []string{"MAPSETNAME", "MAPSETVERSION", "MAPSETSID", "FROMRSAB", "FROMVSAB", "TORSAB", "TOVSAB"}
+
+func (mapSets MapSets) add(attribute Attribute) {
+	if attributes, ok := mapSets[attribute.CUI]; ok {
+		attributes[attribute.ATN] = attribute.ATV
+	} else {
+		mapSets[attribute.CUI] = map[string]string{attribute.ATN: attribute.ATV}
+	}
+}
+
+// Finds the code system a map set maps from or to, by its root source abbreviation (e.g. TORSAB) or else the versioned
+// source abbreviation (e.g. TOVSAB, such as ICD10CM_2024).
+func (mapSets MapSets) source(cui string, prefix string) (string, umlsSource, bool) {
+	attributes := mapSets[cui]
+	if source, ok := umlsSources[attributes[prefix+"RSAB"]]; ok {
+		return attributes[prefix+"RSAB"], source, true
+	}
+	for sab, source := range umlsSources {
+		if strings.HasPrefix(attributes[prefix+"VSAB"], sab+"_") {
+			return sab, source, true
+		}
+	}
+	return "", umlsSource{}, false
+}
+
+// Loads the mappings of each map set from MRMAP.RRF. Since the map set attributes identifying the target code system
+// are loaded separately from MRSAT.RRF, each map set is stored as a placeholder ConceptMap to be completed by
+// LoadConceptMaps.
+func LoadMappings(db *DB, batchSize int, file io.Reader) error {
+	n := 0
+	conceptMaps := make(map[string]int64, 8)
+
+	fmt.Println("Loading mappings:")
+	db.Batch()
+	parse := func(line []byte) (Mapping, bool) {
+		mapping := ParseMapping(line)
+		_, ok := umlsSources[mapping.MAPSETSAB]
+		return mapping, ok
+	}
+	err := parseRows(file, parse, func(mapping Mapping) error {
+		conceptMap, ok := conceptMaps[mapping.MAPSETCUI]
+		if !ok {
+			_, err := db.Prep(`INSERT INTO "ConceptMap" (_id, url, json) VALUES ($1, '', '{}') RETURNING id`, mapping.MAPSETCUI).
+				First(&conceptMap)
+			if err != nil {
+				return err
+			}
+			conceptMaps[mapping.MAPSETCUI] = conceptMap
+		}
+
+		mapGroup, _ := strconv.Atoi(mapping.MAPSUBSETID)
+		err := db.Prep(`INSERT INTO "ConceptMap_Element" ("conceptMap", "sourceCode", "mapGroup", priority, "targetCode", rule, advice)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			conceptMap, mapping.FROMEXPR, mapGroup, mapping.MAPRANK, mapping.TOEXPR, mapping.MAPRULE, mapping.MAPRES).Exec()
+		if err != nil {
+			return err
+		}
+
+		n++
+		if n%batchSize == 0 {
+			db.Flush()
+			fmt.Print(".")
+			db.Batch()
+		}
+		return nil
+	})
+	db.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("✅")
+	fmt.Printf("======================\n(total %d mappings in %d map sets)\n\n", n, len(conceptMaps))
+	return nil
+}
+
+// Completes the ConceptMap for each map set loaded by LoadMappings, using the map set attributes to resolve the source
+// and target codes of its mappings. Map sets between code systems which are not loaded are removed.
+func LoadConceptMaps(db *DB, mapSets MapSets) error {
+	fmt.Println("Loading concept maps:")
+	type placeholder struct {
+		id  int64
+		cui string
+	}
+	var placeholders []placeholder
+	err := db.Prep(`SELECT id, _id FROM "ConceptMap" WHERE url = ''`).Each(func(rows *Rows) error {
+		var p placeholder
+		if err := rows.Scan(&p.id, &p.cui); err != nil {
+			return err
+		}
+		placeholders = append(placeholders, p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range placeholders {
+		attributes := mapSets[p.cui]
+		fromSAB, from, fromOK := mapSets.source(p.cui, "FROM")
+		toSAB, to, toOK := mapSets.source(p.cui, "TO")
+		if !fromOK || !toOK {
+			fmt.Printf("%s ❌ (unknown source or target code system)\n", p.cui)
+			if err := db.Prep(`DELETE FROM "ConceptMap_Element" WHERE "conceptMap" = $1`, p.id).Exec(); err != nil {
+				return err
+			}
+			if err := db.Prep(`DELETE FROM "ConceptMap" WHERE id = $1`, p.id).Exec(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// SNOMED CT map reference sets are identified by an implicit ConceptMap URL, which is also used for other map sets
+		// @see http://hl7.org/fhir/R4/snomedct.html#implicit-cm
+		setID := attributes["MAPSETSID"]
+		if setID == "" {
+			setID = p.cui
+		}
+		url := from.resource.Url + "?fhir_cm=" + setID
+		id := uuid.NewSHA1(uuid.NameSpaceURL, []byte(url)).String()
+		resource, err := json.Marshal(map[string]any{
+			"resourceType": "ConceptMap",
+			"id":           id,
+			"url":          url,
+			"version":      attributes["MAPSETVERSION"],
+			"title":        attributes["MAPSETNAME"],
+			"status":       "active",
+			"sourceUri":    from.resource.Url + "?fhir_vs",
+			"targetUri":    to.resource.Url + "?fhir_vs",
+		})
+		if err != nil {
+			return err
+		}
+
+		db.Batch()
+		err = db.Prep(`UPDATE "ConceptMap" SET _id = $2, url = $3, json = $4, "sourceSystem" = $5, "targetSystem" = $6 WHERE id = $1`,
+			p.id, id, url, string(resource), from.resource.dbID, to.resource.dbID).Exec()
+		if err == nil {
+			err = db.Prep(`UPDATE "ConceptMap_Element" SET
+					source = (SELECT id FROM "Coding" WHERE system = $2 AND code = "sourceCode"),
+					target = (SELECT id FROM "Coding" WHERE system = $3 AND code = "targetCode")
+				WHERE "conceptMap" = $1`, p.id, from.resource.dbID, to.resource.dbID).Exec()
+		}
+		db.Flush()
+		if err != nil {
+			fmt.Printf("%s ❌\n", url)
+			return err
+		}
+
+		var count int64
+		if _, err := db.Prep(`SELECT count(*) FROM "ConceptMap_Element" WHERE "conceptMap" = $1`, p.id).First(&count); err != nil {
+			return err
+		}
+		fmt.Printf("%s (%s to %s): %d mappings ✅\n", url, fromSAB, toSAB, count)
+	}
+
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConceptMaps(t *testing.T) {
+	require := require.New(t)
+	db := loadTestRelease(t)
+
+	// Only the map set between loaded code systems is kept: the ICD-9-CM target and MedDRA source are not loaded
+	var urls []string
+	err := db.Prep(`SELECT url FROM "ConceptMap" ORDER BY url`).Each(func(rows *Rows) error {
+		var url string
+		urls = append(urls, url)
+		return rows.Scan(&urls[len(urls)-1])
+	})
+	require.NoError(err)
+	require.Equal([]string{"http://snomed.info/sct?fhir_cm=6011000124106"}, urls)
+	var elements int64
+	_, err = db.Prep(`SELECT count(*) FROM "ConceptMap_Element"`).First(&elements)
+	require.NoError(err)
+	require.EqualValues(4, elements)
+
+	var (
+		id                         int64
+		resourceJSON, source, dest string
+	)
+	_, err = db.Prep(`SELECT "ConceptMap".id, "ConceptMap".json, src.url, dst.url FROM "ConceptMap"
+		JOIN "CodeSystem" src ON src.id = "sourceSystem"
+		JOIN "CodeSystem" dst ON dst.id = "targetSystem"`).First(&id, &resourceJSON, &source, &dest)
+	require.NoError(err)
+	require.Equal("http://snomed.info/sct", source)
+	require.Equal("http://hl7.org/fhir/sid/icd-10-cm", dest)
+	var resource map[string]any
+	require.NoError(json.Unmarshal([]byte(resourceJSON), &resource))
+	require.Equal("SNOMED CT to ICD-10-CM Mapping", resource["title"])
+	require.Equal("20230901", resource["version"])
+	require.Equal("http://snomed.info/sct?fhir_vs", resource["sourceUri"])
+	require.Equal("http://hl7.org/fhir/sid/icd-10-cm?fhir_vs", resource["targetUri"])
+
+	type element struct {
+		sourceCode, targetCode string
+		priority               int64
+		hasSource, hasTarget   bool
+	}
+	var actual []element
+	err = db.Prep(`SELECT "sourceCode", "targetCode", priority, source IS NOT NULL, target IS NOT NULL
+		FROM "ConceptMap_Element" WHERE "conceptMap" = $1 ORDER BY "sourceCode", priority`, id).Each(func(rows *Rows) error {
+		var e element
+		if err := rows.Scan(&e.sourceCode, &e.targetCode, &e.priority, &e.hasSource, &e.hasTarget); err != nil {
+			return err
+		}
+		actual = append(actual, e)
+		return nil
+	})
+	require.NoError(err)
+	require.Equal([]element{
+		{sourceCode: "22298006", targetCode: "I21.9", priority: 1, hasSource: true, hasTarget: true},
+		// Targets missing from the release are kept by code
+		{sourceCode: "22298006", targetCode: "I25.2", priority: 2, hasSource: true},
+		{sourceCode: "44054006", targetCode: "E11.9", priority: 1, hasSource: true, hasTarget: true},
+		// Source codes which cannot be mapped have no target
+		{sourceCode: "64572001", priority: 1, hasSource: true},
+	}, actual)
+}
+
+func TestMapSetsSource(t *testing.T) {
+	defer func(sources map[string]umlsSource) { umlsSources = sources }(umlsSources)
+	umlsSources = map[string]umlsSource{"SNOMEDCT_US": {}, "ICD10CM": {}, "LNC": {}}
+	mapSets := MapSets{
+		"C1": {"FROMRSAB": "SNOMEDCT_US", "TORSAB": "ICD10CM"},
+		"C2": {"FROMVSAB": "SNOMEDCT_US_2023_09_01", "TOVSAB": "ICD10CM_2024"},
+		"C3": {"FROMRSAB": "SNOMEDCT_US", "TOVSAB": "ICD9CM_2013"},
+		"C4": {"FROMRSAB": "unknown", "FROMVSAB": "LNC_276"},
+	}
+	tests := []struct {
+		cui, prefix string
+		sab         string
+		ok          bool
+	}{
+		{cui: "C1", prefix: "FROM", sab: "SNOMEDCT_US", ok: true},
+		{cui: "C1", prefix: "TO", sab: "ICD10CM", ok: true},
+		{cui: "C2", prefix: "FROM", sab: "SNOMEDCT_US", ok: true},
+		{cui: "C2", prefix: "TO", sab: "ICD10CM", ok: true},
+		{cui: "C3", prefix: "TO"},
+		{cui: "C4", prefix: "FROM", sab: "LNC", ok: true},
+		{cui: "C5", prefix: "FROM"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.cui+" "+test.prefix, func(t *testing.T) {
+			sab, _, ok := mapSets.source(test.cui, test.prefix)
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.sab, sab)
+		})
+	}
+}
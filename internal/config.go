@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed resources/build.yaml resources/CodeSystem/*.json
+var resources embed.FS
+
+// Configuration of a build: the UMLS release to load and the source vocabularies to include from it.
+type BuildConfig struct {
+	// Path to the UMLS release archive, or a directory containing the extracted release files.
+	Release string `yaml:"release"`
+	// Paths to the individual release files to load (e.g. MRCONSO.RRF), instead of the whole release.
+	Files []string `yaml:"files"`
+	// Languages to load designations for in addition to English.
+	Languages []string `yaml:"languages"`
+	// Directory or NDJSON file of FHIR ValueSet resources to load (optional).
+	ValueSets string `yaml:"valueSets"`
+	// Source vocabularies to load, keyed by UMLS source abbreviation (SAB).
+	Sources map[string]SourceConfig `yaml:"sources"`
+	// Whether to load in bulk mode, committing in large transactions.
+	Bulk bool `yaml:"bulk"`
+
+	// Called once the release files are loaded, before the hierarchy closure and concept maps are computed from them,
+	// e.g. to create indexes which are deferred in bulk mode.
+	AfterLoad func(db *DB) error `yaml:"-"`
+
+	// ----- Private fields -----
+
+	// Directory which relative paths in the config are resolved against.
+	dir string
+}
+
+// Configuration of a UMLS source vocabulary, loaded as a code system.
+type SourceConfig struct {
+	// Canonical URL of the code system.
+	Url string `yaml:"url"`
+	// Term types (TTY) to load, in order of preference for the display of each code.
+	TTY []string `yaml:"tty"`
+	// Path to the CodeSystem JSON definition, or one of the embedded definitions.
+	CodeSystem string `yaml:"codeSystem"`
+	// SABs or glob patterns of the sources which translate this one into other languages.
+	Translations []string `yaml:"translations"`
+}
+
+// Returns the default build configuration, which loads the embedded code systems from the 2023AB UMLS release in the
+// working directory.
+func DefaultBuildConfig() *BuildConfig {
+	file, err := resources.ReadFile("resources/build.yaml")
+	if err != nil {
+		panic(err)
+	}
+	cfg, err := parseBuildConfig(file, ".")
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// Reads a build configuration file in YAML or JSON format. Relative paths in the file are resolved against its
+// directory, and sources are taken from the default configuration if none are given.
+func ReadBuildConfig(file string) (*BuildConfig, error) {
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseBuildConfig(contents, filepath.Dir(file))
+	if err != nil {
+		return nil, fmt.Errorf("invalid build config %s: %w", file, err)
+	}
+	if len(cfg.Sources) == 0 {
+		cfg.Sources = DefaultBuildConfig().Sources
+	}
+	return cfg, nil
+}
+
+func parseBuildConfig(contents []byte, dir string) (*BuildConfig, error) {
+	cfg := &BuildConfig{dir: dir}
+	// JSON is a subset of YAML, so both formats are parsed the same way. Unknown keys are rejected, so that a misspelled
+	// option fails the build rather than silently building a different database
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Resolves a path from the config file against its directory.
+func (cfg *BuildConfig) Path(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(cfg.dir, p)
+}
+
+// Returns the number of rows to write in each transaction while loading release files.
+func (cfg *BuildConfig) batchSize() int {
+	if cfg.Bulk {
+		return bulkBatchSize
+	}
+	return defaultBatchSize
+}
+
+// Reads the CodeSystem definition of a source from the config directory, or else from the embedded definitions.
+func (cfg *BuildConfig) readCodeSystem(source SourceConfig) ([]byte, error) {
+	if source.CodeSystem == "" {
+		return nil, errors.New("missing codeSystem")
+	}
+	contents, err := os.ReadFile(cfg.Path(source.CodeSystem))
+	if errors.Is(err, fs.ErrNotExist) && !filepath.IsAbs(source.CodeSystem) {
+		contents, err = resources.ReadFile(path.Clean(filepath.ToSlash(source.CodeSystem)))
+	}
+	return contents, err
+}
+
+// Configures the UMLS sources to load, reading the CodeSystem definition of each.
+func (cfg *BuildConfig) configureSources() error {
+	if len(cfg.Sources) == 0 {
+		return errors.New("no sources configured")
+	}
+
+	sources := make(map[string]umlsSource, len(cfg.Sources))
+	for sab, source := range cfg.Sources {
+		contents, err := cfg.readCodeSystem(source)
+		if err != nil {
+			return fmt.Errorf("error reading CodeSystem for source %s: %w", sab, err)
+		}
+		resource, err := parseCodeSystem(contents)
+		if err != nil {
+			return fmt.Errorf("invalid CodeSystem for source %s: %w", sab, err)
+		}
+
+		if source.Url == "" {
+			source.Url = resource.Url
+		} else if resource.Url != "" && resource.Url != source.Url {
+			return fmt.Errorf("url %s of source %s does not match its CodeSystem url %s", source.Url, sab, resource.Url)
+		}
+		resource.Url = source.Url
+		if len(source.TTY) == 0 {
+			return fmt.Errorf("no term types (tty) configured for source %s", sab)
+		}
+		for _, pattern := range source.Translations {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid translation pattern %s for source %s: %w", pattern, sab, err)
+			}
+		}
+
+		sources[sab] = umlsSource{
+			systemID:     uuid.NewSHA1(uuid.NameSpaceURL, []byte(source.Url)),
+			tty:          source.TTY,
+			translations: source.Translations,
+			json:         contents,
+			resource:     resource,
+		}
+	}
+	umlsSources = sources
+	return nil
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBuildConfig(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := ReadBuildConfig("testdata/config/build.yaml")
+	require.NoError(err)
+	require.Equal("testdata/release", cfg.Path(cfg.Release))
+	require.Equal([]string{"../release/MRCONSO.RRF", "/tmp/MRDOC.RRF"}, cfg.Files)
+	require.Equal("testdata/release/MRCONSO.RRF", cfg.Path(cfg.Files[0]))
+	// Absolute paths are not resolved against the config directory
+	require.Equal("/tmp/MRDOC.RRF", cfg.Path(cfg.Files[1]))
+	require.Equal("testdata/config/valuesets.ndjson", cfg.Path(cfg.ValueSets))
+	require.Equal([]string{"es", "fr"}, cfg.Languages)
+	require.True(cfg.Bulk)
+	require.Equal(bulkBatchSize, cfg.batchSize())
+	require.Equal(map[string]SourceConfig{
+		"LNC":     {TTY: []string{"LC", "LPDN"}, CodeSystem: "resources/CodeSystem/loinc.json"},
+		"EXAMPLE": {TTY: []string{"PT"}, CodeSystem: "example.json", Translations: []string{"EXAMPLE_*"}},
+	}, cfg.Sources)
+}
+
+func TestReadBuildConfigJSON(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := ReadBuildConfig("testdata/config/build.json")
+	require.NoError(err)
+	require.Equal(filepath.Join("testdata", "config", "umls-2024AA-full.zip"), cfg.Path(cfg.Release))
+	require.Equal([]string{"de"}, cfg.Languages)
+	require.Empty(cfg.Path(cfg.ValueSets))
+	require.False(cfg.Bulk)
+	require.Equal(defaultBatchSize, cfg.batchSize())
+	require.Equal(map[string]SourceConfig{
+		"LNC": {Url: "http://loinc.org", TTY: []string{"LC"}, CodeSystem: "resources/CodeSystem/loinc.json"},
+	}, cfg.Sources)
+}
+
+func TestReadBuildConfigDefaultSources(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := ReadBuildConfig("testdata/config/default-sources.yaml")
+	require.NoError(err)
+	require.Equal("umls-2024AA-full.zip", cfg.Release)
+	require.Equal(DefaultBuildConfig().Sources, cfg.Sources)
+	require.Contains(cfg.Sources, "SNOMEDCT_US")
+}
+
+func TestReadBuildConfigEmpty(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := ReadBuildConfig("testdata/config/empty.yaml")
+	require.NoError(err)
+	require.Empty(cfg.Release)
+	require.Equal(DefaultBuildConfig().Sources, cfg.Sources)
+}
+
+func TestReadBuildConfigError(t *testing.T) {
+	_, err := ReadBuildConfig("testdata/config/invalid.yaml")
+	require.ErrorContains(t, err, "invalid build config testdata/config/invalid.yaml: yaml:")
+
+	_, err = ReadBuildConfig("testdata/config/unknown-field.yaml")
+	require.ErrorContains(t, err, "invalid build config testdata/config/unknown-field.yaml: yaml: unmarshal errors:\n  line 5: field ttys not found in type internal.SourceConfig")
+
+	_, err = ReadBuildConfig("testdata/config/missing.yaml")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestConfigureSources(t *testing.T) {
+	require := require.New(t)
+	defer func(sources map[string]umlsSource) { umlsSources = sources }(umlsSources)
+
+	cfg, err := ReadBuildConfig("testdata/config/build.yaml")
+	require.NoError(err)
+	require.NoError(cfg.configureSources())
+
+	require.Len(umlsSources, 2)
+	// The url is taken from the CodeSystem definition when the source does not give one
+	require.Equal("http://loinc.org", umlsSources["LNC"].resource.Url)
+	require.Equal([]string{"LC", "LPDN"}, umlsSources["LNC"].tty)
+	// Definitions in the config directory are read before the embedded ones
+	example := umlsSources["EXAMPLE"]
+	require.Equal("http://example.org/fhir/CodeSystem/example", example.resource.Url)
+	require.Equal([]string{"EXAMPLE_*"}, example.translations)
+	require.NotEqual(umlsSources["LNC"].systemID, example.systemID)
+}
+
+func TestConfigureSourcesError(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources map[string]SourceConfig
+		err     string
+	}{
+		{
+			name: "no sources",
+			err:  "no sources configured",
+		},
+		{
+			name:    "missing codeSystem",
+			sources: map[string]SourceConfig{"LNC": {TTY: []string{"LC"}}},
+			err:     "error reading CodeSystem for source LNC: missing codeSystem",
+		},
+		{
+			name:    "unknown codeSystem",
+			sources: map[string]SourceConfig{"LNC": {TTY: []string{"LC"}, CodeSystem: "resources/CodeSystem/missing.json"}},
+			err:     "error reading CodeSystem for source LNC: open resources/CodeSystem/missing.json: file does not exist",
+		},
+		{
+			name:    "invalid codeSystem",
+			sources: map[string]SourceConfig{"EXAMPLE": {TTY: []string{"PT"}, CodeSystem: "not-codesystem.json"}},
+			err:     "invalid CodeSystem for source EXAMPLE: Invalid resource type: ValueSet",
+		},
+		{
+			name: "url mismatch",
+			sources: map[string]SourceConfig{
+				"LNC": {Url: "http://example.org/loinc", TTY: []string{"LC"}, CodeSystem: "resources/CodeSystem/loinc.json"},
+			},
+			err: "url http://example.org/loinc of source LNC does not match its CodeSystem url http://loinc.org",
+		},
+		{
+			name:    "no term types",
+			sources: map[string]SourceConfig{"LNC": {CodeSystem: "resources/CodeSystem/loinc.json"}},
+			err:     "no term types (tty) configured for source LNC",
+		},
+		{
+			name: "invalid translation pattern",
+			sources: map[string]SourceConfig{
+				"LNC": {TTY: []string{"LC"}, CodeSystem: "resources/CodeSystem/loinc.json", Translations: []string{"LNC_["}},
+			},
+			err: "invalid translation pattern LNC_[ for source LNC: syntax error in pattern",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &BuildConfig{Sources: test.sources, dir: "testdata/config"}
+			require.EqualError(t, cfg.configureSources(), test.err)
+		})
+	}
+}
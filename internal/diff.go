@@ -0,0 +1,324 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// A difference between two databases, e.g. built from consecutive UMLS releases.
+type Change struct {
+	// Kind of change, one of the Change* constants.
+	Type string `json:"type"`
+	// Canonical URL of the code system.
+	System string `json:"system"`
+	Code   string `json:"code,omitempty"`
+	// Property code, for property changes.
+	Property string `json:"property,omitempty"`
+	// Canonical URL of the value set (with its version, as url|version, if it has one), for membership changes.
+	ValueSet string `json:"valueSet,omitempty"`
+	// Value in the old database, e.g. the display or property value.
+	Old string `json:"old,omitempty"`
+	// Value in the new database.
+	New string `json:"new,omitempty"`
+}
+
+const (
+	ChangeSystemAdded     = "codesystem-added"
+	ChangeSystemRemoved   = "codesystem-removed"
+	ChangeCodeAdded       = "code-added"
+	ChangeCodeRetired     = "code-retired"
+	ChangeDisplay         = "display-changed"
+	ChangePropertyAdded   = "property-added"
+	ChangePropertyRemoved = "property-removed"
+	ChangeProperty        = "property-changed"
+	ChangeValueSetAdded   = "valueset-added"
+	ChangeValueSetRemoved = "valueset-removed"
+	ChangeMemberAdded     = "member-added"
+	ChangeMemberRemoved   = "member-removed"
+)
+
+// Counts of the changes to a code system, by change type.
+type SystemSummary struct {
+	System     string
+	OldVersion string
+	NewVersion string
+	// Whether the code system is only in the new database
+	Added bool
+	// Whether the code system is only in the old database
+	Removed bool
+	Changes map[string]int
+}
+
+// Compares the codes, their properties and value set memberships in the database with those in an older database file,
+// calling emit for each change. Changes are grouped by code system, and the returned summary counts them for each one.
+// Codes of added or removed code systems, and members of added or removed value sets, are not listed individually.
+func Diff(db *DB, oldPath string, emit func(change Change) error) ([]*SystemSummary, error) {
+	// Both databases must be queried on the same connection for the old one to be attached to it
+	conn, err := db.Acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	if err := conn.Prep(`ATTACH DATABASE $1 AS "old"`, oldPath).Exec(); err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", oldPath, err)
+	}
+	defer conn.Prep(`DETACH DATABASE "old"`).Exec()
+
+	summaries, err := diffSystems(conn)
+	if err != nil {
+		return nil, err
+	}
+	valueSets, err := diffValueSets(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, summary := range summaries {
+		count := func(change Change) error {
+			summary.Changes[change.Type]++
+			return emit(change)
+		}
+		switch {
+		case summary.Added:
+			err = count(Change{Type: ChangeSystemAdded, System: summary.System, New: summary.NewVersion})
+		case summary.Removed:
+			err = count(Change{Type: ChangeSystemRemoved, System: summary.System, Old: summary.OldVersion})
+		default:
+			err = diffCodes(conn, summary.System, count)
+			if err == nil {
+				err = diffProperties(conn, summary.System, count)
+			}
+			if err == nil {
+				err = diffMembers(conn, summary.System, valueSets, count)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error comparing %s: %w", summary.System, err)
+		}
+	}
+	return summaries, nil
+}
+
+// Lists the code systems in either database, marking those which were added or removed.
+func diffSystems(db *DB) ([]*SystemSummary, error) {
+	bySystem := make(map[string]*SystemSummary)
+	for _, schema := range []string{"old", "main"} {
+		err := db.PrepTransient(`SELECT url, coalesce(json_extract(CAST(json AS TEXT), '$.version'), '')
+			FROM "` + schema + `"."CodeSystem"`).Each(func(rows *Rows) error {
+			var url, version string
+			if err := rows.Scan(&url, &version); err != nil {
+				return err
+			}
+			summary, ok := bySystem[url]
+			if !ok {
+				// Code systems are assumed to be added until found in both databases
+				summary = &SystemSummary{System: url, Added: schema == "main", Changes: make(map[string]int)}
+				bySystem[url] = summary
+			}
+			if schema == "old" {
+				summary.OldVersion = version
+				summary.Removed = true
+			} else {
+				summary.NewVersion = version
+				summary.Removed = false
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	summaries := make([]*SystemSummary, 0, len(bySystem))
+	for _, summary := range bySystem {
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].System < summaries[j].System })
+	return summaries, nil
+}
+
+// Finds codes which were added, retired (no longer in the new database), or have a different display.
+func diffCodes(db *DB, system string, emit func(change Change) error) error {
+	// Codes of the code system in the schema named by the first which are not in the other named by the second, or
+	// which also match the condition
+	const query = `SELECT "Coding".code, coalesce("Coding".display, ''), "Other".id IS NOT NULL, coalesce("Other".display, '')
+		FROM "%[1]s"."Coding" "Coding" JOIN "%[1]s"."CodeSystem" "System" ON "System".id = "Coding".system
+		LEFT JOIN "%[2]s"."CodeSystem" "OtherSystem" ON "OtherSystem".url = "System".url
+		LEFT JOIN "%[2]s"."Coding" "Other" ON "Other".system = "OtherSystem".id AND "Other".code = "Coding".code
+		WHERE "System".url = $1 AND ("Other".id IS NULL OR %[3]s)
+		ORDER BY "Coding".code`
+
+	err := db.PrepTransient(fmt.Sprintf(query, "main", "old", `"Other".display IS NOT "Coding".display`), system).Each(func(rows *Rows) error {
+		var code, display, oldDisplay string
+		var existed bool
+		if err := rows.Scan(&code, &display, &existed, &oldDisplay); err != nil {
+			return err
+		}
+		if !existed {
+			return emit(Change{Type: ChangeCodeAdded, System: system, Code: code, New: display})
+		}
+		return emit(Change{Type: ChangeDisplay, System: system, Code: code, Old: oldDisplay, New: display})
+	})
+	if err != nil {
+		return err
+	}
+	return db.PrepTransient(fmt.Sprintf(query, "old", "main", "0"), system).Each(func(rows *Rows) error {
+		var code, display string
+		if err := rows.Scan(&code, &display); err != nil {
+			return err
+		}
+		return emit(Change{Type: ChangeCodeRetired, System: system, Code: code, Old: display})
+	})
+}
+
+// Finds property values which were added or removed for codes in both databases. When a single value of a property
+// is replaced by another (e.g. a LOINC STATUS changing from ACTIVE to DEPRECATED), it is reported as a change instead.
+func diffProperties(db *DB, system string, emit func(change Change) error) error {
+	// Property values in the schema named by the first which are not in the other named by the second, for codes in both
+	const query = `SELECT "Coding".code, "Prop".code, coalesce("Code_Prop".value, '')
+		FROM "%[1]s"."Coding_Property" "Code_Prop"
+		JOIN "%[1]s"."CodeSystem_Property" "Prop" ON "Prop".id = "Code_Prop".property
+		JOIN "%[1]s"."Coding" "Coding" ON "Coding".id = "Code_Prop".coding
+		JOIN "%[1]s"."CodeSystem" "System" ON "System".id = "Coding".system
+		WHERE "System".url = $1 AND EXISTS (SELECT 1 FROM "%[2]s"."Coding" "Other"
+			JOIN "%[2]s"."CodeSystem" "OtherSystem" ON "OtherSystem".id = "Other".system
+			WHERE "OtherSystem".url = $1 AND "Other".code = "Coding".code)
+		EXCEPT
+		SELECT "Coding".code, "Prop".code, coalesce("Code_Prop".value, '')
+		FROM "%[2]s"."Coding_Property" "Code_Prop"
+		JOIN "%[2]s"."CodeSystem_Property" "Prop" ON "Prop".id = "Code_Prop".property
+		JOIN "%[2]s"."Coding" "Coding" ON "Coding".id = "Code_Prop".coding
+		JOIN "%[2]s"."CodeSystem" "System" ON "System".id = "Coding".system
+		WHERE "System".url = $1
+		ORDER BY 1, 2, 3`
+
+	type key struct{ code, property string }
+	type values struct{ old, new []string }
+	var keys []key
+	changed := make(map[key]*values)
+	for _, schemas := range [][2]string{{"old", "main"}, {"main", "old"}} {
+		err := db.PrepTransient(fmt.Sprintf(query, schemas[0], schemas[1]), system).Each(func(rows *Rows) error {
+			var k key
+			var value string
+			if err := rows.Scan(&k.code, &k.property, &value); err != nil {
+				return err
+			}
+			v, ok := changed[k]
+			if !ok {
+				v = &values{}
+				changed[k] = v
+				keys = append(keys, k)
+			}
+			if schemas[0] == "old" {
+				v.old = append(v.old, value)
+			} else {
+				v.new = append(v.new, value)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].code != keys[j].code {
+			return keys[i].code < keys[j].code
+		}
+		return keys[i].property < keys[j].property
+	})
+	for _, k := range keys {
+		v := changed[k]
+		var changes []Change
+		if len(v.old) == 1 && len(v.new) == 1 {
+			changes = append(changes, Change{Type: ChangeProperty, Old: v.old[0], New: v.new[0]})
+		} else {
+			for _, value := range v.old {
+				changes = append(changes, Change{Type: ChangePropertyRemoved, Old: value})
+			}
+			for _, value := range v.new {
+				changes = append(changes, Change{Type: ChangePropertyAdded, New: value})
+			}
+		}
+		for _, change := range changes {
+			change.System, change.Code, change.Property = system, k.code, k.property
+			if err := emit(change); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SQL expression identifying the "ValueSet" row in scope by its URL and version, since several versions of a value set
+// may be loaded.
+const valueSetKeySQL = `"ValueSet".url || coalesce('|' || json_extract(CAST("ValueSet".json AS TEXT), '$.version'), '')`
+
+// Determines which value sets are in each database, keyed by URL and version: true for those in both, and false for
+// those only in one.
+func diffValueSets(db *DB) (map[string]bool, error) {
+	valueSets := make(map[string]bool)
+	for _, schema := range []string{"old", "main"} {
+		err := db.PrepTransient(`SELECT DISTINCT ` + valueSetKeySQL + ` FROM "` + schema + `"."ValueSet" "ValueSet"`).Each(func(rows *Rows) error {
+			var valueSet string
+			if err := rows.Scan(&valueSet); err != nil {
+				return err
+			}
+			_, seen := valueSets[valueSet]
+			valueSets[valueSet] = seen && schema == "main"
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return valueSets, nil
+}
+
+// Finds codes of the code system which were added to or removed from value sets in both databases. Value sets which
+// were added or removed are reported once for each code system they contain codes of, rather than each of their codes.
+func diffMembers(db *DB, system string, valueSets map[string]bool, emit func(change Change) error) error {
+	// Members in the schema named by the first which are not in the other named by the second
+	const query = `SELECT ` + valueSetKeySQL + `, "Coding".code FROM "%[1]s"."ValueSet_Membership" "Member"
+		JOIN "%[1]s"."ValueSet" "ValueSet" ON "ValueSet".id = "Member"."valueSet"
+		JOIN "%[1]s"."Coding" "Coding" ON "Coding".id = "Member".coding
+		JOIN "%[1]s"."CodeSystem" "System" ON "System".id = "Coding".system
+		WHERE "System".url = $1
+		EXCEPT
+		SELECT ` + valueSetKeySQL + `, "Coding".code FROM "%[2]s"."ValueSet_Membership" "Member"
+		JOIN "%[2]s"."ValueSet" "ValueSet" ON "ValueSet".id = "Member"."valueSet"
+		JOIN "%[2]s"."Coding" "Coding" ON "Coding".id = "Member".coding
+		JOIN "%[2]s"."CodeSystem" "System" ON "System".id = "Coding".system
+		WHERE "System".url = $1
+		ORDER BY 1, 2`
+
+	for _, schemas := range [][2]string{{"old", "main"}, {"main", "old"}} {
+		added := schemas[0] == "main"
+		reported := make(map[string]bool)
+		err := db.PrepTransient(fmt.Sprintf(query, schemas[0], schemas[1]), system).Each(func(rows *Rows) error {
+			var valueSet, code string
+			if err := rows.Scan(&valueSet, &code); err != nil {
+				return err
+			}
+			switch {
+			case valueSets[valueSet] && added:
+				return emit(Change{Type: ChangeMemberAdded, System: system, ValueSet: valueSet, Code: code})
+			case valueSets[valueSet]:
+				return emit(Change{Type: ChangeMemberRemoved, System: system, ValueSet: valueSet, Code: code})
+			case reported[valueSet]:
+				return nil
+			case added:
+				reported[valueSet] = true
+				return emit(Change{Type: ChangeValueSetAdded, System: system, ValueSet: valueSet})
+			default:
+				reported[valueSet] = true
+				return emit(Change{Type: ChangeValueSetRemoved, System: system, ValueSet: valueSet})
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/mattwiller/hawthorn/internal/testdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	require := require.New(t)
+
+	// The test database is compared with a copy of it as the old release, modified to differ in each way
+	oldPath := testdb.Copy(t, "../umls.db", "umls-old.db", append(testdb.EarlierRelease,
+		`UPDATE "Coding_Property" SET value = 'TRIAL' WHERE value = 'ACTIVE'`,
+		`INSERT INTO "Coding" (system, code, display) SELECT id, '0000-0', 'Retired test' FROM "CodeSystem" WHERE url = 'http://loinc.org'`,
+		`DELETE FROM "CodeSystem" WHERE url = 'http://hl7.org/fhir/sid/cvx'`,
+	)...)
+
+	db, err := internal.OpenReadOnly("../umls.db", 1)
+	require.NoError(err)
+	defer db.Close()
+
+	var changes []internal.Change
+	summaries, err := internal.Diff(db, oldPath, func(change internal.Change) error {
+		if change.System == "http://loinc.org" || change.System == "http://hl7.org/fhir/sid/cvx" {
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	require.NoError(err)
+
+	require.Equal([]internal.Change{
+		{Type: internal.ChangeSystemAdded, System: "http://hl7.org/fhir/sid/cvx", New: "20230816"},
+		{Type: internal.ChangeCodeAdded, System: "http://loinc.org", Code: "2345-7", New: "Glucose [Mass/volume] in Serum or Plasma"},
+		{Type: internal.ChangeDisplay, System: "http://loinc.org", Code: "79741-5", Old: "Brain MRI findings related to eye", New: "Eye-related brain MRI findings"},
+		{Type: internal.ChangeCodeRetired, System: "http://loinc.org", Code: "0000-0", Old: "Retired test"},
+		{Type: internal.ChangeProperty, System: "http://loinc.org", Code: "79741-5", Property: "STATUS", Old: "TRIAL", New: "ACTIVE"},
+		{Type: internal.ChangeMemberAdded, System: "http://loinc.org", Code: "2345-7", ValueSet: "http://example.com/vs/combined"},
+		{Type: internal.ChangeMemberAdded, System: "http://loinc.org", Code: "2345-7", ValueSet: "http://example.com/vs/expanded"},
+		{Type: internal.ChangeMemberAdded, System: "http://loinc.org", Code: "2345-7", ValueSet: "http://example.com/vs/labs"},
+	}, changes)
+
+	for _, summary := range summaries {
+		if summary.System == "http://loinc.org" {
+			require.Equal("2.74", summary.OldVersion)
+			require.Equal("2.76", summary.NewVersion)
+			require.Equal(1, summary.Changes[internal.ChangeCodeRetired])
+			require.Equal(3, summary.Changes[internal.ChangeMemberAdded])
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package fhir
+
+import (
+	"slices"
+
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+type codeSystem struct {
+	id      int64
+	url     string
+	title   string
+	version string
+	// Database containing the code system, which is one of the attached releases for a non-default version
+	db *internal.DB
+}
+
+// Finds a loaded code system by its canonical URL, returning nil if it does not exist.
+func findCodeSystem(db *internal.DB, url string) (*codeSystem, error) {
+	system := new(codeSystem)
+	found, err := db.Prep(`SELECT id, url, title, json_extract(CAST(json AS TEXT), '$.version') FROM "CodeSystem" WHERE url = $1`, url).
+		First(&system.id, &system.url, &system.title, &system.version)
+	if err != nil || !found {
+		return nil, err
+	}
+	system.db = db
+	return system, nil
+}
+
+// Finds the requested version of a code system, in the database or else the databases of other releases attached to
+// it. When no version is requested, the default version is the one in the first database containing the code system:
+// the server's own database, unless the code system is only loaded in an attached release. If the code system is loaded
+// but not in the requested version, nil is returned along with the versions which are available.
+func findCodeSystemVersion(db *internal.DB, url string, version string) (*codeSystem, []string, error) {
+	var available []string
+	for _, release := range append([]*internal.DB{db}, db.Releases()...) {
+		system, err := findCodeSystem(release, url)
+		if err != nil {
+			return nil, nil, err
+		} else if system == nil {
+			continue
+		} else if version == "" || version == system.version {
+			return system, nil, nil
+		}
+		if system.version != "" && !slices.Contains(available, system.version) {
+			available = append(available, system.version)
+		}
+	}
+	return nil, available, nil
+}
+
+// SQL expression which evaluates to true when the "Coding" row in scope is inactive, as indicated by its properties.
+const codingInactiveSQL = `EXISTS (SELECT 1 FROM "Coding_Property" "Code_Prop"
+	JOIN "CodeSystem_Property" "Prop" ON "Prop".id = "Code_Prop".property
+	WHERE "Code_Prop".coding = "Coding".id AND (
+		("Prop".code = 'STATUS' AND "Code_Prop".value = 'DEPRECATED') OR
+		("Prop".code = 'inactive' AND "Code_Prop".value = 'true')
+	))`
+
+type coding struct {
+	id      int64
+	code    string
+	display string
+}
+
+// Finds a code within the given code system, returning nil if it does not exist.
+func findCoding(db *internal.DB, system *codeSystem, code string) (*coding, error) {
+	c := new(coding)
+	found, err := db.Prep(`SELECT id, code, display FROM "Coding" WHERE system = $1 AND code = $2`, system.id, code).
+		First(&c.id, &c.code, &c.display)
+	if err != nil || !found {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Determines whether the ancestor coding subsumes the descendant, using the precomputed hierarchy closure.
+func subsumes(db *internal.DB, ancestor, descendant *coding) (bool, error) {
+	return db.Prep(`SELECT 1 FROM "Coding_Closure" WHERE ancestor = $1 AND descendant = $2`, ancestor.id, descendant.id).First()
+}
+
+type designation struct {
+	language string
+	use      *Coding
+	value    string
+}
+
+// Finds the designations (display strings) for a code, including its display.
+func findDesignations(db *internal.DB, c *coding) ([]designation, error) {
+	var designations []designation
+	err := db.Prep(`SELECT "CodeSystem".url, "Coding_Designation".language, "Coding_Designation".use, "Coding_Designation".value
+		FROM "Coding_Designation"
+		JOIN "Coding" ON "Coding".id = "Coding_Designation".coding
+		JOIN "CodeSystem" ON "CodeSystem".id = "Coding".system
+		WHERE "Coding_Designation".coding = $1
+		ORDER BY "Coding_Designation".id`, c.id).Each(func(rows *internal.Rows) error {
+		var system, tty string
+		var d designation
+		if err := rows.Scan(&system, &d.language, &tty, &d.value); err != nil {
+			return err
+		}
+		d.use = designationUse(system, tty)
+		designations = append(designations, d)
+		return nil
+	})
+	return designations, err
+}
+
+// Descriptions of the UMLS term types (TTY) of loaded designations.
+// @see https://www.nlm.nih.gov/research/umls/knowledge_sources/metathesaurus/release/abbreviations.html#TTY
+var termTypes = map[string]string{
+	"DN":   "Display Name",
+	"FN":   "Full form of descriptor",
+	"GLP":  "Global period",
+	"GPCK": "Generic Drug Delivery Device",
+	"HC":   "Hierarchical class",
+	"HT":   "Hierarchical term",
+	"LA":   "LOINC answer",
+	"LC":   "Long common name",
+	"LG":   "LOINC group",
+	"LN":   "LOINC official fully specified name",
+	"LPDN": "LOINC parts display name",
+	"MIN":  "name for a multi-ingredient",
+	"MP":   "Preferred names of modifiers",
+	"POS":  "Place of service",
+	"PSN":  "Prescribable Name",
+	"PT":   "Designated preferred name",
+	"SBD":  "Semantic branded drug",
+	"SBDG": "Semantic branded drug group",
+	"SCD":  "Semantic Clinical Drug",
+	"SCDG": "Semantic clinical drug group",
+	"SY":   "Designated synonym",
+}
+
+// Determines the use of a designation from its UMLS term type. SNOMED CT term types are mapped to the corresponding
+// SNOMED CT description types, while other term types are identified by their TTY code alone.
+// @see http://hl7.org/fhir/R4/snomedct.html#designations
+func designationUse(system, tty string) *Coding {
+	if system == "http://snomed.info/sct" {
+		switch tty {
+		case "FN":
+			return &Coding{System: system, Code: "900000000000003001", Display: "Fully specified name"}
+		case "PT", "SY":
+			return &Coding{System: system, Code: "900000000000013009", Display: "Synonym"}
+		}
+	}
+	return &Coding{Code: tty, Display: termTypes[tty]}
+}
+
+// Determines whether the coding is inactive, as indicated by its properties.
+func isInactive(db *internal.DB, c *coding) (bool, error) {
+	var inactive bool
+	_, err := db.Prep(`SELECT `+codingInactiveSQL+` FROM "Coding" WHERE id = $1`, c.id).First(&inactive)
+	return inactive, err
+}
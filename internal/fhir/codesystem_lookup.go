@@ -1,7 +1,10 @@
 package fhir
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/mattwiller/hawthorn/internal"
 )
@@ -9,55 +12,181 @@ import (
 // Implements the CodeSystem/$lookup operation endpoint.
 // @see http://hl7.org/fhir/R4B/codesystem-operation-lookup.html
 func CodeSystemLookupHandler(db *internal.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query()
-		if !query.Has("system") || !query.Has("code") {
-			sendError(w, "required", "Coding must be specified using 'system' and 'code' query parameters")
+	return handleOperation(db, func(db *internal.DB, w http.ResponseWriter, r *http.Request) {
+		input, err := readInput(r)
+		if err != nil {
+			sendError(w, "invalid", err.Error())
 			return
 		}
 
-		system := query.Get("system")
-		code := query.Get("code")
+		coding := input.Coding("coding")
+		if coding == nil && input.Has("system") && input.Has("code") {
+			coding = &Coding{System: input.Get("system"), Code: input.Get("code")}
+		}
+		if coding == nil || coding.System == "" || coding.Code == "" {
+			sendError(w, "required", "Coding must be specified using 'system' and 'code' or 'coding' parameters")
+			return
+		}
+		if coding.Version == "" {
+			coding.Version = input.Get("version")
+		}
 
-		results, err := db.Query(`SELECT id,title FROM "CodeSystem" WHERE url = $1`, system)
+		codeSystem, versions, err := findCodeSystemVersion(db, coding.System, coding.Version)
 		if err != nil {
+			sendError(w, "exception", "Error finding code system")
+			return
+		} else if codeSystem == nil && len(versions) > 0 {
+			sendError(w, "not-found", fmt.Sprintf("Code system version not found (available versions: %s)", strings.Join(versions, ", ")))
+			return
+		} else if codeSystem == nil {
 			sendError(w, "not-found", "Code system not found")
 			return
 		}
-		codeSystem := results[0]
-		systemID := codeSystem["id"].(int64)
+		// The code is looked up in the release containing the requested version of the code system
+		db = codeSystem.db
 
-		results, err = db.Query(`SELECT id,display FROM "Coding" WHERE "Coding".system = $1 AND "Coding".code = $2;`, systemID, code)
+		code, err := findCoding(db, codeSystem, coding.Code)
 		if err != nil {
+			sendError(w, "exception", "Error finding code")
+			return
+		} else if code == nil {
+			sendError(w, "not-found", "Code not found")
 			return
 		}
-		codingID := results[0]["id"].(int64)
-		display := results[0]["display"].(string)
 
-		results, err = db.Query(`SELECT "Prop".*, "Code_Prop".value, "Code_Prop".target FROM "Coding_Property" "Code_Prop" JOIN "Coding" ON "Code_Prop".coding = "Coding".id
-			JOIN "CodeSystem_Property" "Prop" ON "Prop".id = "Code_Prop".property WHERE "Coding".id = $1`, codingID)
-		if err != nil {
-			return
+		if languages := displayLanguages(input, r); len(languages) > 0 {
+			_, err := db.Prep(`SELECT `+localizedDisplaySQL(1)+` FROM "Coding" WHERE id = $2`, internal.JSONArray(languages), code.id).
+				First(&code.display)
+			if err != nil {
+				sendError(w, "exception", "Error finding code display")
+				return
+			}
 		}
 
+		requested := newPropertySelection(input.Values("property"))
 		output := []map[string]any{
-			{"name": "name", "valueString": codeSystem["title"].(string)},
-			{"name": "display", "valueString": display},
+			{"name": "name", "valueString": codeSystem.title},
 		}
-		for _, property := range results {
-			propType := capitalize(property["type"].(string))
-			if propType == "Coding" {
-				property["value"] = map[string]any{
-					"code": property["value"],
+		if codeSystem.version != "" {
+			output = append(output, map[string]any{"name": "version", "valueString": codeSystem.version})
+		}
+		output = append(output, map[string]any{"name": "display", "valueString": code.display})
+		if requested.designations {
+			designations, err := findDesignations(db, code)
+			if err != nil {
+				sendError(w, "exception", "Error finding code designations")
+				return
+			}
+			for _, d := range designations {
+				if len(requested.languages) > 0 && !slices.Contains(requested.languages, d.language) {
+					continue
 				}
+				output = append(output, formatDesignation(d))
+			}
+		}
+		if requested.inactive {
+			inactive, err := isInactive(db, code)
+			if err != nil {
+				sendError(w, "exception", "Error finding code status")
+				return
+			}
+			output = append(output, map[string]any{"name": "property", "part": []map[string]any{
+				{"name": "code", "valueCode": "inactive"},
+				{"name": "value", "valueBoolean": inactive},
+			}})
+		}
+		query := `SELECT "Prop".code, "Prop".type, "Prop".description, "Code_Prop".value FROM "Coding_Property" "Code_Prop"
+			JOIN "CodeSystem_Property" "Prop" ON "Prop".id = "Code_Prop".property WHERE "Code_Prop".coding = $1`
+		args := []any{code.id}
+		if !requested.all {
+			query += ` AND ("Prop".code IN (SELECT value FROM json_each($2)) OR "Prop".uri IN (SELECT value FROM json_each($3)))`
+			args = append(args, internal.JSONArray(requested.codes), internal.JSONArray(requested.uris))
+		}
+		// Properties are listed in the order they were loaded from the release, rather than whichever order the index
+		// chosen by the query planner returns them in
+		query += ` ORDER BY "Code_Prop".rowid`
+		err = db.Prep(query, args...).Each(func(rows *internal.Rows) error {
+			var propCode, propType string
+			var description, value any
+			if err := rows.Scan(&propCode, &propType, &description, &value); err != nil {
+				return err
+			}
+
+			propType = capitalize(propType)
+			if propType == "Coding" {
+				value = map[string]any{"code": value}
 			}
 			output = append(output, map[string]any{"name": "property", "part": []map[string]any{
-				{"name": "code", "valueCode": property["code"]},
-				{"name": "description", "valueString": property["description"]},
-				{"name": "value", "value" + capitalize(property["type"].(string)): property["value"]},
+				{"name": "code", "valueCode": propCode},
+				{"name": "description", "valueString": description},
+				{"name": "value", "value" + propType: value},
 			}})
+			return nil
+		})
+		if err != nil {
+			sendError(w, "exception", "Error finding code properties")
+			return
 		}
 
 		sendOutput(w, output)
+	})
+}
+
+// The properties requested for a $lookup, using the 'property' input parameter.
+type propertySelection struct {
+	// No specific properties were requested, so all stored properties and designations are returned
+	all bool
+	// Property codes to return
+	codes []string
+	// Property URIs to return, for properties defined by FHIR (e.g. parent) which code systems may name differently
+	uris         []string
+	designations bool
+	// Languages of the designations to return, from 'lang.X' properties; all languages are returned if empty
+	languages []string
+	inactive  bool
+}
+
+func newPropertySelection(properties []string) propertySelection {
+	if len(properties) == 0 {
+		return propertySelection{all: true, designations: true}
+	}
+
+	requested := propertySelection{codes: []string{}, uris: []string{}}
+	for _, property := range properties {
+		switch {
+		case property == "designation":
+			requested.designations = true
+		case strings.HasPrefix(property, "lang."):
+			requested.designations = true
+			requested.languages = append(requested.languages, strings.TrimPrefix(property, "lang."))
+		case property == "inactive":
+			requested.inactive = true
+		case property == "parent":
+			requested.codes = append(requested.codes, property)
+			requested.uris = append(requested.uris, internal.PARENT_URI)
+		case property == "child":
+			requested.codes = append(requested.codes, property)
+			requested.uris = append(requested.uris, internal.CHILD_URI)
+		case property == "definition":
+			// Definitions are not loaded from UMLS, so there is never a value to return
+		default:
+			requested.codes = append(requested.codes, property)
+		}
+	}
+	if requested.designations && slices.Contains(properties, "designation") {
+		requested.languages = nil
+	}
+	return requested
+}
+
+func formatDesignation(d designation) map[string]any {
+	var parts []map[string]any
+	if d.language != "" {
+		parts = append(parts, map[string]any{"name": "language", "valueCode": d.language})
+	}
+	if d.use != nil {
+		parts = append(parts, map[string]any{"name": "use", "valueCoding": d.use})
 	}
+	parts = append(parts, map[string]any{"name": "value", "valueString": d.value})
+	return map[string]any{"name": "designation", "part": parts}
 }
@@ -1,12 +1,16 @@
 package fhir_test
 
 import (
+	"encoding/json"
 	"io"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/mattwiller/hawthorn/internal"
 	"github.com/mattwiller/hawthorn/internal/fhir"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -30,7 +34,18 @@ func TestCodeSystemLookup(t *testing.T) {
 		"resourceType": "Parameters",
 		"parameter": [
 			{"name": "name", "valueString": "LOINC Code System"},
+			{"name": "version", "valueString": "2.76"},
 			{"name": "display", "valueString": "Eye-related brain MRI findings"},
+			{"name": "designation", "part": [
+				{"name": "language", "valueCode": "en"},
+				{"name": "use", "valueCoding": {"code": "LC", "display": "Long common name"}},
+				{"name": "value", "valueString": "Eye-related brain MRI findings"}
+			]},
+			{"name": "designation", "part": [
+				{"name": "language", "valueCode": "en"},
+				{"name": "use", "valueCoding": {"code": "LN", "display": "LOINC official fully specified name"}},
+				{"name": "value", "valueString": "Eye-related brain MRI findings:Find:Pt:^Patient:Nom"}
+			]},
 			{"name": "property", "part": [
 				{"name": "code", "valueCode": "parent"},
 				{"name": "description", "valueString": "A parent code in the Component Hierarchy by System"},
@@ -111,6 +126,263 @@ func TestCodeSystemLookup(t *testing.T) {
 	require.JSONEq(expected, string(body))
 }
 
+func TestCodeSystemLookupPost(t *testing.T) {
+	require := require.New(t)
+
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(err)
+	srv := fhir.CodeSystemLookupHandler(db)
+
+	req := httptest.NewRequest("GET", "/R4/CodeSystem/$lookup?system=http://loinc.org&code=79741-5", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+	expected, err := io.ReadAll(res.Result().Body)
+	require.NoError(err)
+
+	req = httptest.NewRequest("POST", "/R4/CodeSystem/$lookup", strings.NewReader(`{
+		"resourceType": "Parameters",
+		"parameter": [
+			{"name": "coding", "valueCoding": {"system": "http://loinc.org", "code": "79741-5"}},
+			{"name": "displayLanguage", "valueCode": "en"}
+		]
+	}`))
+	res = httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(200, res.Result().StatusCode)
+
+	body, err := io.ReadAll(res.Result().Body)
+	require.NoError(err)
+	require.JSONEq(string(expected), string(body))
+}
+
+func TestCodeSystemLookupProperty(t *testing.T) {
+	require := require.New(t)
+
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(err)
+	srv := fhir.CodeSystemLookupHandler(db)
+
+	req := httptest.NewRequest("GET", "/R4/CodeSystem/$lookup?system=http://loinc.org&code=79741-5&property=STATUS&property=inactive", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(200, res.Result().StatusCode)
+
+	body, err := io.ReadAll(res.Result().Body)
+	require.NoError(err)
+	require.JSONEq(`{
+		"resourceType": "Parameters",
+		"parameter": [
+			{"name": "name", "valueString": "LOINC Code System"},
+			{"name": "version", "valueString": "2.76"},
+			{"name": "display", "valueString": "Eye-related brain MRI findings"},
+			{"name": "property", "part": [
+				{"name": "code", "valueCode": "inactive"},
+				{"name": "value", "valueBoolean": false}
+			]},
+			{"name": "property", "part": [
+				{"name": "code", "valueCode": "STATUS"},
+				{"name": "description", "valueString": "Status of the term. Within LOINC, codes with STATUS=DEPRECATED are considered inactive. Current values: ACTIVE, TRIAL, DISCOURAGED, and DEPRECATED"},
+				{"name": "value", "valueString": "ACTIVE"}
+			]}
+		]
+	}`, string(body))
+}
+
+func TestCodeSystemLookupDesignations(t *testing.T) {
+	require := require.New(t)
+
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(err)
+	srv := fhir.CodeSystemLookupHandler(db)
+
+	req := httptest.NewRequest("GET", "/R4/CodeSystem/$lookup?system=http://snomed.info/sct&code=22298006&property=designation", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(200, res.Result().StatusCode)
+
+	body, err := io.ReadAll(res.Result().Body)
+	require.NoError(err)
+	require.JSONEq(`{
+		"resourceType": "Parameters",
+		"parameter": [
+			{"name": "name", "valueString": "SNOMED CT (US Edition)"},
+			{"name": "version", "valueString": "http://snomed.info/sct/731000124108/version/20230901"},
+			{"name": "display", "valueString": "Myocardial infarction (disorder)"},
+			{"name": "designation", "part": [
+				{"name": "language", "valueCode": "en"},
+				{"name": "use", "valueCoding": {"system": "http://snomed.info/sct", "code": "900000000000003001", "display": "Fully specified name"}},
+				{"name": "value", "valueString": "Myocardial infarction (disorder)"}
+			]},
+			{"name": "designation", "part": [
+				{"name": "language", "valueCode": "en"},
+				{"name": "use", "valueCoding": {"system": "http://snomed.info/sct", "code": "900000000000013009", "display": "Synonym"}},
+				{"name": "value", "valueString": "Myocardial infarction"}
+			]},
+			{"name": "designation", "part": [
+				{"name": "language", "valueCode": "en"},
+				{"name": "use", "valueCoding": {"system": "http://snomed.info/sct", "code": "900000000000013009", "display": "Synonym"}},
+				{"name": "value", "valueString": "Heart attack"}
+			]},
+			{"name": "designation", "part": [
+				{"name": "language", "valueCode": "es"},
+				{"name": "use", "valueCoding": {"system": "http://snomed.info/sct", "code": "900000000000013009", "display": "Synonym"}},
+				{"name": "value", "valueString": "infarto de miocardio"}
+			]}
+		]
+	}`, string(body))
+}
+
+func TestCodeSystemLookupDisplayLanguage(t *testing.T) {
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(t, err)
+	srv := fhir.CodeSystemLookupHandler(db)
+
+	tests := []struct {
+		name           string
+		url            string
+		acceptLanguage string
+		expected       string
+	}{
+		{name: "displayLanguage", url: "?system=http://snomed.info/sct&code=22298006&displayLanguage=es", expected: "infarto de miocardio"},
+		{name: "regional variant", url: "?system=http://loinc.org&code=2345-7&displayLanguage=es-MX", expected: "Glucosa [Masa/volumen] en Suero o Plasma"},
+		{name: "Accept-Language", url: "?system=http://snomed.info/sct&code=22298006", acceptLanguage: "fr;q=0.5, es-MX, es;q=0.9", expected: "infarto de miocardio"},
+		{name: "English preferred", url: "?system=http://snomed.info/sct&code=22298006", acceptLanguage: "en-US, es;q=0.9", expected: "Myocardial infarction (disorder)"},
+		{name: "no translation", url: "?system=http://snomed.info/sct&code=73211009&displayLanguage=es", expected: "Diabetes mellitus (disorder)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			req := httptest.NewRequest("GET", "/R4/CodeSystem/$lookup"+test.url+"&property=display", nil)
+			if test.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", test.acceptLanguage)
+			}
+			res := httptest.NewRecorder()
+			srv.ServeHTTP(res, req)
+
+			require.Equal(200, res.Result().StatusCode)
+
+			var output struct {
+				Parameter []struct {
+					Name        string `json:"name"`
+					ValueString string `json:"valueString"`
+				} `json:"parameter"`
+			}
+			require.NoError(json.NewDecoder(res.Result().Body).Decode(&output))
+			require.Equal("display", output.Parameter[2].Name)
+			require.Equal(test.expected, output.Parameter[2].ValueString)
+		})
+	}
+}
+
+func TestCodeSystemLookupErrors(t *testing.T) {
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(t, err)
+	srv := fhir.CodeSystemLookupHandler(db)
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		accept      string
+		status      int
+		code        string
+	}{
+		{name: "missing parameters", method: "GET", url: "/R4/CodeSystem/$lookup", status: 400, code: "required"},
+		{name: "unknown system", method: "GET", url: "/R4/CodeSystem/$lookup?system=http://example.com&code=1", status: 404, code: "not-found"},
+		{name: "unknown code", method: "GET", url: "/R4/CodeSystem/$lookup?system=http://loinc.org&code=0000-0", status: 404, code: "not-found"},
+		{name: "unsupported method", method: "PUT", url: "/R4/CodeSystem/$lookup?system=http://loinc.org&code=79741-5", status: 405, code: "not-supported"},
+		{name: "unacceptable format", method: "GET", url: "/R4/CodeSystem/$lookup?system=http://loinc.org&code=79741-5", accept: "application/fhir+xml", status: 406, code: "not-supported"},
+		{name: "excluded format", method: "GET", url: "/R4/CodeSystem/$lookup?system=http://loinc.org&code=79741-5", accept: "application/fhir+json;q=0.0, application/json;q=0.000", status: 406, code: "not-supported"},
+		{name: "invalid format quality", method: "GET", url: "/R4/CodeSystem/$lookup?system=http://loinc.org&code=79741-5", accept: "application/fhir+json;q=high", status: 406, code: "not-supported"},
+		{name: "unsupported body format", method: "POST", url: "/R4/CodeSystem/$lookup", contentType: "application/fhir+xml", status: 415, code: "not-supported"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			req := httptest.NewRequest(test.method, test.url, nil)
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			res := httptest.NewRecorder()
+			srv.ServeHTTP(res, req)
+
+			require.Equal(test.status, res.Result().StatusCode)
+			require.Equal("application/fhir+json; charset=utf-8", res.Result().Header.Get("Content-Type"))
+
+			var outcome struct {
+				ResourceType string
+				Issue        []struct{ Code string }
+			}
+			require.NoError(json.NewDecoder(res.Result().Body).Decode(&outcome))
+			require.Equal("OperationOutcome", outcome.ResourceType)
+			require.Equal(test.code, outcome.Issue[0].Code)
+		})
+	}
+}
+
+func TestCodeSystemLookupFormat(t *testing.T) {
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(t, err)
+	srv := fhir.CodeSystemLookupHandler(db)
+
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{accept: "", contentType: "application/fhir+json"},
+		{accept: "application/json", contentType: "application/json"},
+		{accept: "application/fhir+json;q=0.0, application/json;q=0.5", contentType: "application/json"},
+		{accept: "application/fhir+xml, */*;q=0.1", contentType: "application/fhir+json"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/R4/CodeSystem/$lookup?system=http://loinc.org&code=79741-5", nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			res := httptest.NewRecorder()
+			srv.ServeHTTP(res, req)
+
+			require.Equal(t, 200, res.Result().StatusCode)
+			require.Equal(t, test.contentType+"; charset=utf-8", res.Result().Header.Get("Content-Type"))
+		})
+	}
+}
+
+func TestCodeSystemLookupConcurrent(t *testing.T) {
+	db, err := internal.OpenReadOnly("../../umls.db", 4)
+	require.NoError(t, err)
+	defer db.Close()
+	srv := fhir.CodeSystemLookupHandler(db)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				req := httptest.NewRequest("GET", "/R4/CodeSystem/$lookup?system=http://loinc.org&code=79741-5", nil)
+				res := httptest.NewRecorder()
+				srv.ServeHTTP(res, req)
+				assert.Equal(t, 200, res.Result().StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func BenchmarkCodeSystemLookup(b *testing.B) {
 	db, _ := internal.NewDB("../../umls.db")
 	srv := fhir.CodeSystemLookupHandler(db)
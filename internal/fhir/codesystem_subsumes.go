@@ -0,0 +1,98 @@
+package fhir
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+// Implements the CodeSystem/$subsumes operation endpoint.
+// @see http://hl7.org/fhir/R4/codesystem-operation-subsumes.html
+func CodeSystemSubsumesHandler(db *internal.DB) http.HandlerFunc {
+	return handleOperation(db, func(db *internal.DB, w http.ResponseWriter, r *http.Request) {
+		input, err := readInput(r)
+		if err != nil {
+			sendError(w, "invalid", err.Error())
+			return
+		}
+
+		codingA := subsumptionInput(input, "A")
+		codingB := subsumptionInput(input, "B")
+		if codingA == nil || codingB == nil {
+			sendError(w, "required", "Codes must be specified using 'codeA' and 'codeB' with 'system', or 'codingA' and 'codingB' parameters")
+			return
+		} else if codingA.System != codingB.System {
+			sendError(w, "business-rule", "Codes to compare must be from the same code system")
+			return
+		}
+
+		system, err := findCodeSystem(db, codingA.System)
+		if err != nil {
+			sendError(w, "exception", "Error finding code system")
+			return
+		} else if system == nil {
+			sendError(w, "not-found", "Code system not found")
+			return
+		}
+
+		a, err := findCoding(db, system, codingA.Code)
+		if err != nil {
+			sendError(w, "exception", "Error finding code")
+			return
+		} else if a == nil {
+			sendError(w, "not-found", fmt.Sprintf("Code '%s' not found", codingA.Code))
+			return
+		}
+		b, err := findCoding(db, system, codingB.Code)
+		if err != nil {
+			sendError(w, "exception", "Error finding code")
+			return
+		} else if b == nil {
+			sendError(w, "not-found", fmt.Sprintf("Code '%s' not found", codingB.Code))
+			return
+		}
+
+		outcome, err := subsumptionOutcome(db, a, b)
+		if err != nil {
+			sendError(w, "exception", "Error checking subsumption")
+			return
+		}
+
+		sendOutput(w, []map[string]any{
+			{"name": "outcome", "valueCode": outcome},
+		})
+	})
+}
+
+// Reads one side of the comparison, from either the 'codingX' or 'codeX' and 'system' parameters.
+func subsumptionInput(input *operationInput, side string) *Coding {
+	if coding := input.Coding("coding" + side); coding != nil {
+		if coding.System == "" {
+			coding.System = input.Get("system")
+		}
+		return coding
+	} else if input.Has("code"+side) && input.Has("system") {
+		return &Coding{System: input.Get("system"), Code: input.Get("code" + side)}
+	}
+	return nil
+}
+
+func subsumptionOutcome(db *internal.DB, a, b *coding) (string, error) {
+	if a.id == b.id {
+		return "equivalent", nil
+	}
+
+	if ok, err := subsumes(db, a, b); err != nil {
+		return "", err
+	} else if ok {
+		return "subsumes", nil
+	}
+
+	if ok, err := subsumes(db, b, a); err != nil {
+		return "", err
+	} else if ok {
+		return "subsumed-by", nil
+	}
+	return "not-subsumed", nil
+}
@@ -0,0 +1,77 @@
+package fhir_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/mattwiller/hawthorn/internal/fhir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeSystemSubsumes(t *testing.T) {
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(t, err)
+	srv := fhir.CodeSystemSubsumesHandler(db)
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		body    string
+		outcome string
+	}{
+		{
+			name:    "equivalent",
+			method:  "GET",
+			url:     "/R4/CodeSystem/$subsumes?system=http://loinc.org&codeA=79741-5&codeB=79741-5",
+			outcome: "equivalent",
+		},
+		{
+			name:    "subsumes",
+			method:  "GET",
+			url:     "/R4/CodeSystem/$subsumes?system=http://loinc.org&codeA=LP408570-2&codeB=79741-5",
+			outcome: "subsumes",
+		},
+		{
+			name:    "subsumed-by",
+			method:  "GET",
+			url:     "/R4/CodeSystem/$subsumes?system=http://loinc.org&codeA=79741-5&codeB=LP408570-2",
+			outcome: "subsumed-by",
+		},
+		{
+			name:    "not-subsumed",
+			method:  "GET",
+			url:     "/R4/CodeSystem/$subsumes?system=http://loinc.org&codeA=79741-5&codeB=2345-7",
+			outcome: "not-subsumed",
+		},
+		{
+			name:   "POST with codings",
+			method: "POST",
+			url:    "/R4/CodeSystem/$subsumes",
+			body: `{"resourceType": "Parameters", "parameter": [
+				{"name": "codingA", "valueCoding": {"system": "http://loinc.org", "code": "LP408570-2"}},
+				{"name": "codingB", "valueCoding": {"system": "http://loinc.org", "code": "79741-5"}}
+			]}`,
+			outcome: "subsumes",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			res := httptest.NewRecorder()
+			srv.ServeHTTP(res, req)
+
+			require.Equal(200, res.Result().StatusCode)
+
+			body, err := io.ReadAll(res.Result().Body)
+			require.NoError(err)
+			require.JSONEq(`{"resourceType": "Parameters", "parameter": [{"name": "outcome", "valueCode": "`+test.outcome+`"}]}`, string(body))
+		})
+	}
+}
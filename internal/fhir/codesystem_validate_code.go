@@ -0,0 +1,168 @@
+package fhir
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+// Implements the CodeSystem/$validate-code operation endpoint.
+// @see http://hl7.org/fhir/R4/codesystem-operation-validate-code.html
+func CodeSystemValidateCodeHandler(db *internal.DB) http.HandlerFunc {
+	return handleOperation(db, func(db *internal.DB, w http.ResponseWriter, r *http.Request) {
+		input, err := readInput(r)
+		if err != nil {
+			sendError(w, "invalid", err.Error())
+			return
+		}
+
+		system := input.Get("url")
+		if system == "" {
+			system = input.Get("system")
+		}
+		codings := inputCodings(input, system, input.Get("version"))
+		if len(codings) == 0 {
+			sendError(w, "required", "Code must be specified using 'code' and 'url', 'coding', or 'codeableConcept' parameters")
+			return
+		}
+
+		var results []*validationResult
+		for _, c := range codings {
+			result, err := validateCoding(db, c)
+			if err != nil {
+				sendError(w, "exception", "Error validating code")
+				return
+			}
+			results = append(results, result)
+		}
+
+		sendOutput(w, formatValidationResults(results))
+	})
+}
+
+// Collects the codings to validate from the mutually exclusive 'code', 'coding', and 'codeableConcept' inputs, using
+// the given code system and version for any that do not specify one.
+func inputCodings(input *operationInput, system string, version string) []Coding {
+	var codings []Coding
+	if coding := input.Coding("coding"); coding != nil {
+		codings = append(codings, *coding)
+	} else if concept := input.CodeableConcept("codeableConcept"); concept != nil {
+		codings = append(codings, concept.Coding...)
+	} else if input.Has("code") {
+		codings = append(codings, Coding{
+			System:  system,
+			Version: version,
+			Code:    input.Get("code"),
+			Display: input.Get("display"),
+		})
+	}
+
+	for i := range codings {
+		if codings[i].System == "" {
+			codings[i].System = system
+		}
+		if codings[i].Version == "" {
+			codings[i].Version = version
+		}
+	}
+	return codings
+}
+
+type validationResult struct {
+	result  bool
+	message string
+	display string
+	coding  *coding
+	// Code system version the code was found in
+	system *codeSystem
+}
+
+// Marks the coding as invalid, keeping any earlier messages so that every problem found is reported.
+func (result *validationResult) fail(message string) {
+	result.result = false
+	if result.message != "" {
+		result.message += "; "
+	}
+	result.message += message
+}
+
+func validateCoding(db *internal.DB, c Coding) (*validationResult, error) {
+	if c.System == "" || c.Code == "" {
+		return &validationResult{message: "Coding must specify both system and code"}, nil
+	}
+
+	system, versions, err := findCodeSystemVersion(db, c.System, c.Version)
+	if err != nil {
+		return nil, err
+	} else if system == nil && len(versions) > 0 {
+		return &validationResult{
+			message: fmt.Sprintf("Version '%s' of code system '%s' is not available (loaded versions are '%s')", c.Version, c.System, strings.Join(versions, "', '")),
+		}, nil
+	} else if system == nil {
+		return &validationResult{message: fmt.Sprintf("Unknown code system '%s'", c.System)}, nil
+	}
+
+	coding, err := findCoding(system.db, system, c.Code)
+	if err != nil {
+		return nil, err
+	} else if coding == nil {
+		return &validationResult{message: fmt.Sprintf("Unknown code '%s' in code system '%s'", c.Code, c.System)}, nil
+	}
+
+	result := &validationResult{result: true, display: coding.display, coding: coding, system: system}
+	if c.Display != "" {
+		valid, err := isValidDisplay(system.db, coding, strings.TrimSpace(c.Display))
+		if err != nil {
+			return nil, err
+		} else if !valid {
+			result.fail(fmt.Sprintf("Display '%s' is not valid for code '%s' in code system '%s', expected '%s'", c.Display, c.Code, c.System, coding.display))
+		}
+	}
+	return result, nil
+}
+
+// Determines whether the display matches the preferred display of the coding, or any of its designations (such as a
+// synonym, or a display in another language).
+func isValidDisplay(db *internal.DB, c *coding, display string) (bool, error) {
+	if strings.EqualFold(display, c.display) {
+		return true, nil
+	}
+	designations, err := findDesignations(db, c)
+	if err != nil {
+		return false, err
+	}
+	for _, d := range designations {
+		if strings.EqualFold(display, d.value) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Combines the results for each validated coding into output parameters: the concept is valid if any coding is valid.
+func formatValidationResults(results []*validationResult) []map[string]any {
+	best := results[0]
+	var messages []string
+	for _, result := range results {
+		if result.result {
+			best = result
+			messages = nil
+			break
+		} else if result.message != "" {
+			messages = append(messages, result.message)
+		}
+	}
+
+	output := []map[string]any{
+		{"name": "result", "valueBoolean": best.result},
+	}
+	if len(messages) > 0 {
+		output = append(output, map[string]any{"name": "message", "valueString": strings.Join(messages, "; ")})
+	}
+	if best.display != "" {
+		output = append(output, map[string]any{"name": "display", "valueString": best.display})
+	}
+	return output
+}
@@ -0,0 +1,104 @@
+package fhir_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/mattwiller/hawthorn/internal/fhir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeSystemValidateCode(t *testing.T) {
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(t, err)
+	srv := fhir.CodeSystemValidateCodeHandler(db)
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     string
+		expected string
+	}{
+		{
+			name:   "valid code",
+			method: "GET",
+			url:    "/R4/CodeSystem/$validate-code?url=http://loinc.org&code=79741-5",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": true},
+				{"name": "display", "valueString": "Eye-related brain MRI findings"}
+			]}`,
+		},
+		{
+			name:   "incorrect display",
+			method: "GET",
+			url:    "/R4/CodeSystem/$validate-code?url=http://loinc.org&code=79741-5&display=Brain%20MRI",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": false},
+				{"name": "message", "valueString": "Display 'Brain MRI' is not valid for code '79741-5' in code system 'http://loinc.org', expected 'Eye-related brain MRI findings'"},
+				{"name": "display", "valueString": "Eye-related brain MRI findings"}
+			]}`,
+		},
+		{
+			name:   "synonym display",
+			method: "GET",
+			url:    "/R4/CodeSystem/$validate-code?url=http://snomed.info/sct&code=22298006&display=heart%20attack",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": true},
+				{"name": "display", "valueString": "Myocardial infarction (disorder)"}
+			]}`,
+		},
+		{
+			name:   "translated display",
+			method: "GET",
+			url:    "/R4/CodeSystem/$validate-code?url=http://snomed.info/sct&code=22298006&display=Infarto%20de%20miocardio",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": true},
+				{"name": "display", "valueString": "Myocardial infarction (disorder)"}
+			]}`,
+		},
+		{
+			name:   "unknown code",
+			method: "GET",
+			url:    "/R4/CodeSystem/$validate-code?system=http://loinc.org&code=0000-0",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": false},
+				{"name": "message", "valueString": "Unknown code '0000-0' in code system 'http://loinc.org'"}
+			]}`,
+		},
+		{
+			name:   "POST with codeableConcept",
+			method: "POST",
+			url:    "/R4/CodeSystem/$validate-code",
+			body: `{"resourceType": "Parameters", "parameter": [
+				{"name": "codeableConcept", "valueCodeableConcept": {"coding": [
+					{"system": "http://example.com/unknown", "code": "foo"},
+					{"system": "http://loinc.org", "code": "79741-5", "display": "Eye-related brain MRI findings"}
+				]}}
+			]}`,
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": true},
+				{"name": "display", "valueString": "Eye-related brain MRI findings"}
+			]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			res := httptest.NewRecorder()
+			srv.ServeHTTP(res, req)
+
+			require.Equal(200, res.Result().StatusCode)
+
+			body, err := io.ReadAll(res.Result().Body)
+			require.NoError(err)
+			require.JSONEq(test.expected, string(body))
+		})
+	}
+}
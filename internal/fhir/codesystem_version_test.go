@@ -0,0 +1,197 @@
+package fhir_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/mattwiller/hawthorn/internal/fhir"
+	"github.com/mattwiller/hawthorn/internal/testdb"
+	"github.com/stretchr/testify/require"
+)
+
+// Opens a copy of the test database, modified by the given statements.
+func copyDB(t *testing.T, name string, stmts ...string) *internal.DB {
+	db, err := internal.NewDB(testdb.Copy(t, "../../umls.db", name, stmts...))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Opens the test database with a copy of it attached as an earlier release.
+func openWithEarlierRelease(t *testing.T) *internal.DB {
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(t, err)
+	db.AttachReleases(copyDB(t, "umls-earlier.db", testdb.EarlierRelease...))
+	return db
+}
+
+func TestCodeSystemVersions(t *testing.T) {
+	db := openWithEarlierRelease(t)
+	lookup := fhir.CodeSystemLookupHandler(db)
+	validate := fhir.CodeSystemValidateCodeHandler(db)
+
+	tests := []struct {
+		name     string
+		url      string
+		status   int
+		expected string
+	}{
+		{
+			name:   "lookup default version",
+			url:    "/R4/CodeSystem/$lookup?system=http://loinc.org&code=79741-5&property=display",
+			status: 200,
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "name", "valueString": "LOINC Code System"},
+				{"name": "version", "valueString": "2.76"},
+				{"name": "display", "valueString": "Eye-related brain MRI findings"}
+			]}`,
+		},
+		{
+			name:   "lookup earlier version",
+			url:    "/R4/CodeSystem/$lookup?system=http://loinc.org&version=2.74&code=79741-5&property=display",
+			status: 200,
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "name", "valueString": "LOINC Code System"},
+				{"name": "version", "valueString": "2.74"},
+				{"name": "display", "valueString": "Brain MRI findings related to eye"}
+			]}`,
+		},
+		{
+			name:   "lookup unknown version",
+			url:    "/R4/CodeSystem/$lookup?system=http://loinc.org&version=2.50&code=79741-5",
+			status: 404,
+			expected: `{"resourceType": "OperationOutcome", "issue": [
+				{"severity": "error", "code": "not-found", "details": {"text": "Code system version not found (available versions: 2.76, 2.74)"}}
+			]}`,
+		},
+		{
+			name:   "lookup SNOMED CT version",
+			url:    "/R4/CodeSystem/$lookup?system=http://snomed.info/sct&version=http://snomed.info/sct/731000124108/version/20230901&code=22298006&property=display",
+			status: 200,
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "name", "valueString": "SNOMED CT (US Edition)"},
+				{"name": "version", "valueString": "http://snomed.info/sct/731000124108/version/20230901"},
+				{"name": "display", "valueString": "Myocardial infarction (disorder)"}
+			]}`,
+		},
+		{
+			name:   "validate code added after earlier version",
+			url:    "/R4/CodeSystem/$validate-code?url=http://loinc.org&version=2.74&code=2345-7",
+			status: 200,
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": false},
+				{"name": "message", "valueString": "Unknown code '2345-7' in code system 'http://loinc.org'"}
+			]}`,
+		},
+		{
+			name:   "validate display of earlier version",
+			url:    "/R4/CodeSystem/$validate-code?url=http://loinc.org&version=2.74&code=79741-5&display=Brain%20MRI%20findings%20related%20to%20eye",
+			status: 200,
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": true},
+				{"name": "display", "valueString": "Brain MRI findings related to eye"}
+			]}`,
+		},
+		{
+			name:   "validate unknown version",
+			url:    "/R4/CodeSystem/$validate-code?url=http://loinc.org&version=2.50&code=79741-5",
+			status: 200,
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": false},
+				{"name": "message", "valueString": "Version '2.50' of code system 'http://loinc.org' is not available (loaded versions are '2.76', '2.74')"}
+			]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			srv := lookup
+			if strings.HasPrefix(test.url, "/R4/CodeSystem/$validate-code") {
+				srv = validate
+			}
+			req := httptest.NewRequest("GET", test.url, nil)
+			res := httptest.NewRecorder()
+			srv.ServeHTTP(res, req)
+
+			require.Equal(test.status, res.Result().StatusCode)
+
+			body, err := io.ReadAll(res.Result().Body)
+			require.NoError(err)
+			require.JSONEq(test.expected, string(body))
+		})
+	}
+}
+
+func TestCodeSystemVersionsUnversionedDefault(t *testing.T) {
+	require := require.New(t)
+
+	// A code system without a version only serves requests which do not ask for one
+	db := copyDB(t, "umls.db", `UPDATE "CodeSystem" SET json = json_remove(CAST(json AS TEXT), '$.version') WHERE url = 'http://loinc.org'`)
+	db.AttachReleases(copyDB(t, "umls-earlier.db", testdb.EarlierRelease...))
+	srv := fhir.CodeSystemLookupHandler(db)
+
+	req := httptest.NewRequest("GET", "/R4/CodeSystem/$lookup?system=http://loinc.org&version=2.74&code=79741-5&property=display", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(200, res.Result().StatusCode)
+	body, err := io.ReadAll(res.Result().Body)
+	require.NoError(err)
+	require.JSONEq(`{"resourceType": "Parameters", "parameter": [
+		{"name": "name", "valueString": "LOINC Code System"},
+		{"name": "version", "valueString": "2.74"},
+		{"name": "display", "valueString": "Brain MRI findings related to eye"}
+	]}`, string(body))
+
+	req = httptest.NewRequest("GET", "/R4/CodeSystem/$lookup?system=http://loinc.org&version=2.76&code=79741-5", nil)
+	res = httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(404, res.Result().StatusCode)
+	body, err = io.ReadAll(res.Result().Body)
+	require.NoError(err)
+	require.JSONEq(`{"resourceType": "OperationOutcome", "issue": [
+		{"severity": "error", "code": "not-found", "details": {"text": "Code system version not found (available versions: 2.74)"}}
+	]}`, string(body))
+}
+
+func TestCodeSystemVersionsTerminologyCapabilities(t *testing.T) {
+	require := require.New(t)
+
+	db := openWithEarlierRelease(t)
+	srv := fhir.MetadataHandler(db, fhir.Operations)
+
+	req := httptest.NewRequest("GET", "/R4/metadata?mode=terminology", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(200, res.Result().StatusCode)
+
+	var capabilities struct {
+		CodeSystem []struct {
+			Uri     string
+			Version []struct {
+				Code      string
+				IsDefault bool
+			}
+		}
+	}
+	require.NoError(json.NewDecoder(res.Result().Body).Decode(&capabilities))
+	for _, codeSystem := range capabilities.CodeSystem {
+		if codeSystem.Uri == "http://loinc.org" {
+			require.Len(codeSystem.Version, 2)
+			require.Equal("2.76", codeSystem.Version[0].Code)
+			require.True(codeSystem.Version[0].IsDefault)
+			require.Equal("2.74", codeSystem.Version[1].Code)
+			require.False(codeSystem.Version[1].IsDefault)
+			return
+		}
+	}
+	require.Fail("LOINC should be listed in TerminologyCapabilities")
+}
@@ -0,0 +1,228 @@
+package fhir
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+// Implements the ConceptMap/$translate operation endpoint. Codes are translated first using the official UMLS map sets
+// loaded as ConceptMaps, and then using the UMLS concepts (CUIs) they belong to: codes from other systems which share a
+// concept with the source code are matched, with the equivalence determined by how their sets of concepts overlap.
+// When a ConceptMap is specified by its url, only that map is used.
+// @see http://hl7.org/fhir/R4/conceptmap-operation-translate.html
+func ConceptMapTranslateHandler(db *internal.DB) http.HandlerFunc {
+	return handleOperation(db, func(db *internal.DB, w http.ResponseWriter, r *http.Request) {
+		input, err := readInput(r)
+		if err != nil {
+			sendError(w, "invalid", err.Error())
+			return
+		}
+
+		if input.Has("conceptMap") {
+			sendError(w, "not-supported", "Translating with a provided ConceptMap is not supported")
+			return
+		}
+		conceptMapURL := input.Get("url")
+		if conceptMapURL != "" {
+			found, err := db.Prep(`SELECT 1 FROM "ConceptMap" WHERE url = $1`, conceptMapURL).First()
+			if err != nil {
+				sendError(w, "exception", "Error finding ConceptMap")
+				return
+			} else if !found {
+				sendError(w, "not-found", "ConceptMap not found: "+conceptMapURL)
+				return
+			}
+		}
+		codings := inputCodings(input, input.Get("system"), input.Get("version"))
+		if len(codings) == 0 {
+			sendError(w, "required", "Code must be specified using 'code' and 'system', 'coding', or 'codeableConcept' parameters")
+			return
+		}
+		targetSystem := input.Get("targetsystem")
+
+		var matches []translation
+		var messages []string
+		for _, c := range codings {
+			result, err := validateCoding(db, Coding{System: c.System, Version: c.Version, Code: c.Code})
+			if err != nil {
+				sendError(w, "exception", "Error finding code")
+				return
+			} else if result.coding == nil {
+				messages = append(messages, result.message)
+				continue
+			}
+
+			// Codes are translated using the maps and concepts of the release containing the requested version
+			release := result.system.db
+			translations, err := translateMappings(release, result.coding, conceptMapURL, targetSystem)
+			if err != nil {
+				sendError(w, "exception", "Error translating code")
+				return
+			}
+			matches = append(matches, translations...)
+			if conceptMapURL != "" {
+				continue
+			}
+
+			translations, err = translateConcepts(release, result.coding, targetSystem)
+			if err != nil {
+				sendError(w, "exception", "Error translating code")
+				return
+			}
+			for _, t := range translations {
+				// Prefer the official mapping when a code is also matched by its concepts
+				if !slices.ContainsFunc(matches, func(m translation) bool { return m.concept == t.concept }) {
+					matches = append(matches, t)
+				}
+			}
+		}
+
+		matched := slices.ContainsFunc(matches, func(m translation) bool { return m.equivalence != "unmatched" })
+		output := []map[string]any{{"name": "result", "valueBoolean": matched}}
+		if !matched {
+			message := "No translations found"
+			if targetSystem != "" {
+				message += " in code system '" + targetSystem + "'"
+			}
+			messages = append(messages, message)
+		}
+		for _, message := range messages {
+			output = append(output, map[string]any{"name": "message", "valueString": message})
+		}
+		for _, match := range matches {
+			parts := []map[string]any{{"name": "equivalence", "valueCode": match.equivalence}}
+			if match.concept.Code != "" {
+				parts = append(parts, map[string]any{"name": "concept", "valueCoding": match.concept})
+			}
+			for _, product := range match.product {
+				parts = append(parts, map[string]any{"name": "product", "part": []map[string]any{
+					{"name": "element", "valueUri": product.element},
+					{"name": "concept", "valueCoding": product.concept},
+				}})
+			}
+			if match.comment != "" {
+				parts = append(parts, map[string]any{"name": "comment", "valueString": match.comment})
+			}
+			if match.source != "" {
+				parts = append(parts, map[string]any{"name": "source", "valueUri": match.source})
+			}
+			output = append(output, map[string]any{"name": "match", "part": parts})
+		}
+		sendOutput(w, output)
+	})
+}
+
+type translation struct {
+	equivalence string
+	concept     Coding
+	product     []product
+	comment     string
+	source      string // URL of the ConceptMap the translation is from
+}
+
+// An additional output of a mapping, such as the map group, priority and rule of a SNOMED CT rule-based map.
+type product struct {
+	element string
+	concept Coding
+}
+
+// Finds the official mappings of the given code from the stored ConceptMaps, optionally restricted to one ConceptMap
+// or target code system. Mappings are returned in the order they should be applied, by map group and priority.
+func translateMappings(db *internal.DB, source *coding, conceptMapURL, targetSystem string) ([]translation, error) {
+	var translations []translation
+	err := db.Prep(`SELECT "ConceptMap".url, "CodeSystem".url, "ConceptMap_Element"."targetCode", "Coding".display,
+			"ConceptMap_Element"."mapGroup", "ConceptMap_Element".priority, "ConceptMap_Element".rule, "ConceptMap_Element".advice
+		FROM "ConceptMap_Element"
+		JOIN "ConceptMap" ON "ConceptMap".id = "ConceptMap_Element"."conceptMap"
+		JOIN "CodeSystem" ON "CodeSystem".id = "ConceptMap"."targetSystem"
+		LEFT JOIN "Coding" ON "Coding".id = "ConceptMap_Element".target
+		WHERE "ConceptMap_Element".source = $1
+			AND ($2 = '' OR "ConceptMap".url = $2)
+			AND ($3 = '' OR "CodeSystem".url = $3)
+		ORDER BY "ConceptMap".url, "ConceptMap_Element"."mapGroup", "ConceptMap_Element".priority`,
+		source.id, conceptMapURL, targetSystem).Each(func(rows *internal.Rows) error {
+		var t translation
+		var mapGroup, priority int
+		var rule string
+		if err := rows.Scan(&t.source, &t.concept.System, &t.concept.Code, &t.concept.Display, &mapGroup, &priority, &rule, &t.comment); err != nil {
+			return err
+		}
+
+		t.equivalence = "relatedto"
+		if t.concept.Code == "" {
+			// The source code cannot be mapped to the target code system
+			t.equivalence = "unmatched"
+			t.concept = Coding{}
+		}
+		t.product = []product{
+			{element: t.source + "#mapGroup", concept: Coding{Code: strconv.Itoa(mapGroup)}},
+			{element: t.source + "#mapPriority", concept: Coding{Code: strconv.Itoa(priority)}},
+		}
+		if rule != "" {
+			t.product = append(t.product, product{element: t.source + "#mapRule", concept: Coding{Code: rule}})
+		}
+		translations = append(translations, t)
+		return nil
+	})
+	return translations, err
+}
+
+// Equivalences in order of preference, which matches are sorted by.
+// @see http://hl7.org/fhir/R4/valueset-concept-map-equivalence.html
+var equivalences = []string{"equivalent", "wider", "narrower", "inexact"}
+
+// Finds codes which share a UMLS concept with the given code, optionally restricted to a target code system.
+func translateConcepts(db *internal.DB, source *coding, targetSystem string) ([]translation, error) {
+	var sourceCUIs int
+	if _, err := db.Prep(`SELECT count(*) FROM "Coding_CUI" WHERE coding = $1`, source.id).First(&sourceCUIs); err != nil {
+		return nil, err
+	}
+
+	var translations []translation
+	err := db.Prep(`SELECT "CodeSystem".url, "Coding".code, "Coding".display, count(*),
+			(SELECT count(*) FROM "Coding_CUI" "Target" WHERE "Target".coding = "Coding".id)
+		FROM "Coding_CUI" "Source"
+		JOIN "Coding_CUI" ON "Coding_CUI".cui = "Source".cui
+		JOIN "Coding" ON "Coding".id = "Coding_CUI".coding
+		JOIN "CodeSystem" ON "CodeSystem".id = "Coding".system
+		WHERE "Source".coding = $1 AND "Coding".id != $1
+			AND CASE WHEN $2 = '' THEN "Coding".system != (SELECT system FROM "Coding" WHERE id = $1) ELSE "CodeSystem".url = $2 END
+		GROUP BY "Coding".id
+		ORDER BY "CodeSystem".url, "Coding".code`, source.id, targetSystem).Each(func(rows *internal.Rows) error {
+		var t translation
+		var shared, targetCUIs int
+		if err := rows.Scan(&t.concept.System, &t.concept.Code, &t.concept.Display, &shared, &targetCUIs); err != nil {
+			return err
+		}
+		t.equivalence = cuiEquivalence(sourceCUIs, targetCUIs, shared)
+		translations = append(translations, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(translations, func(a, b translation) int {
+		return slices.Index(equivalences, a.equivalence) - slices.Index(equivalences, b.equivalence)
+	})
+	return translations, nil
+}
+
+// Determines the equivalence of a target code to the source from the number of concepts each belongs to, and the
+// number they share. For example, a target code belonging to all of the source code's concepts and others besides has
+// a wider meaning than the source.
+func cuiEquivalence(sourceCUIs, targetCUIs, shared int) string {
+	switch {
+	case shared == sourceCUIs && shared == targetCUIs:
+		return "equivalent"
+	case shared == sourceCUIs:
+		return "wider"
+	case shared == targetCUIs:
+		return "narrower"
+	default:
+		return "inexact"
+	}
+}
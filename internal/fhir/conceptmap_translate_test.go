@@ -0,0 +1,161 @@
+package fhir_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/mattwiller/hawthorn/internal/fhir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConceptMapTranslate(t *testing.T) {
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(t, err)
+	srv := fhir.ConceptMapTranslateHandler(db)
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     string
+		expected string
+	}{
+		{
+			name:   "SNOMED to ICD-10-CM",
+			method: "GET",
+			url:    "/R4/ConceptMap/$translate?system=http://snomed.info/sct&code=22298006&targetsystem=http://hl7.org/fhir/sid/icd-10-cm",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": true},
+				{"name": "match", "part": [
+					{"name": "equivalence", "valueCode": "relatedto"},
+					{"name": "concept", "valueCoding": {"system": "http://hl7.org/fhir/sid/icd-10-cm", "code": "I21.9", "display": "Acute myocardial infarction, unspecified"}},
+					{"name": "product", "part": [
+						{"name": "element", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106#mapGroup"},
+						{"name": "concept", "valueCoding": {"code": "1"}}
+					]},
+					{"name": "product", "part": [
+						{"name": "element", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106#mapPriority"},
+						{"name": "concept", "valueCoding": {"code": "1"}}
+					]},
+					{"name": "product", "part": [
+						{"name": "element", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106#mapRule"},
+						{"name": "concept", "valueCoding": {"code": "IFA 22298006 Myocardial infarction (disorder)"}}
+					]},
+					{"name": "comment", "valueString": "IF MYOCARDIAL INFARCTION CHOOSE I21.9 CONSIDER ADDITIONAL CODE"},
+					{"name": "source", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106"}
+				]},
+				{"name": "match", "part": [
+					{"name": "equivalence", "valueCode": "relatedto"},
+					{"name": "concept", "valueCoding": {"system": "http://hl7.org/fhir/sid/icd-10-cm", "code": "I25.2"}},
+					{"name": "product", "part": [
+						{"name": "element", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106#mapGroup"},
+						{"name": "concept", "valueCoding": {"code": "1"}}
+					]},
+					{"name": "product", "part": [
+						{"name": "element", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106#mapPriority"},
+						{"name": "concept", "valueCoding": {"code": "2"}}
+					]},
+					{"name": "product", "part": [
+						{"name": "element", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106#mapRule"},
+						{"name": "concept", "valueCoding": {"code": "OTHERWISE TRUE"}}
+					]},
+					{"name": "comment", "valueString": "MAP OF SOURCE CONCEPT IS CONTEXT DEPENDENT"},
+					{"name": "source", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106"}
+				]}
+			]}`,
+		},
+		{
+			name:   "POST with coding",
+			method: "POST",
+			url:    "/R4/ConceptMap/$translate",
+			body: `{"resourceType": "Parameters", "parameter": [
+				{"name": "coding", "valueCoding": {"system": "http://snomed.info/sct", "code": "46635009"}},
+				{"name": "targetsystem", "valueUri": "http://hl7.org/fhir/sid/icd-10-cm"}
+			]}`,
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": true},
+				{"name": "match", "part": [
+					{"name": "equivalence", "valueCode": "equivalent"},
+					{"name": "concept", "valueCoding": {"system": "http://hl7.org/fhir/sid/icd-10-cm", "code": "E10.9", "display": "Type 1 diabetes mellitus without complications"}}
+				]}
+			]}`,
+		},
+		{
+			name:   "unmapped code in ConceptMap",
+			method: "GET",
+			url:    "/R4/ConceptMap/$translate?url=http://snomed.info/sct?fhir_cm=6011000124106&system=http://snomed.info/sct&code=64572001",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": false},
+				{"name": "message", "valueString": "No translations found"},
+				{"name": "match", "part": [
+					{"name": "equivalence", "valueCode": "unmatched"},
+					{"name": "product", "part": [
+						{"name": "element", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106#mapGroup"},
+						{"name": "concept", "valueCoding": {"code": "1"}}
+					]},
+					{"name": "product", "part": [
+						{"name": "element", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106#mapPriority"},
+						{"name": "concept", "valueCoding": {"code": "1"}}
+					]},
+					{"name": "product", "part": [
+						{"name": "element", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106#mapRule"},
+						{"name": "concept", "valueCoding": {"code": "TRUE"}}
+					]},
+					{"name": "comment", "valueString": "MAP SOURCE CONCEPT CANNOT BE CLASSIFIED WITH AVAILABLE DATA"},
+					{"name": "source", "valueUri": "http://snomed.info/sct?fhir_cm=6011000124106"}
+				]}
+			]}`,
+		},
+		{
+			name:   "no translation",
+			method: "GET",
+			url:    "/R4/ConceptMap/$translate?system=http://loinc.org&code=79741-5&targetsystem=http://snomed.info/sct",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": false},
+				{"name": "message", "valueString": "No translations found in code system 'http://snomed.info/sct'"}
+			]}`,
+		},
+		{
+			name:   "unknown code",
+			method: "GET",
+			url:    "/R4/ConceptMap/$translate?system=http://snomed.info/sct&code=0",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": false},
+				{"name": "message", "valueString": "Unknown code '0' in code system 'http://snomed.info/sct'"},
+				{"name": "message", "valueString": "No translations found"}
+			]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			res := httptest.NewRecorder()
+			srv.ServeHTTP(res, req)
+
+			require.Equal(200, res.Result().StatusCode)
+
+			body, err := io.ReadAll(res.Result().Body)
+			require.NoError(err)
+			require.JSONEq(test.expected, string(body))
+		})
+	}
+}
+
+func TestConceptMapTranslateUnknownMap(t *testing.T) {
+	require := require.New(t)
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(err)
+	srv := fhir.ConceptMapTranslateHandler(db)
+
+	req := httptest.NewRequest("GET", "/R4/ConceptMap/$translate?url=http://example.com/cm&system=http://snomed.info/sct&code=22298006", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(404, res.Result().StatusCode)
+}
@@ -0,0 +1,42 @@
+package fhir
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+// Describes how the database was built: the UMLS release and versions of the sources loaded from it, the term types
+// and languages loaded, the build time and the Hawthorn commit, as recorded in the build metadata.
+func InfoHandler(db *internal.DB) http.HandlerFunc {
+	return handleRequest(db, []string{http.MethodGet}, func(db *internal.DB, w http.ResponseWriter, r *http.Request) {
+		// Databases built before the metadata was recorded do not have the table
+		recorded, err := db.Prep(`SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'Metadata'`).First()
+		if err != nil {
+			sendError(w, "exception", "Error reading build metadata")
+			return
+		} else if !recorded {
+			sendError(w, "not-found", "Build metadata is not recorded in the database")
+			return
+		}
+
+		info := make(map[string]json.RawMessage)
+		err = db.Prep(`SELECT key, value FROM "Metadata" ORDER BY key`).Each(func(rows *internal.Rows) error {
+			var key, value string
+			if err := rows.Scan(&key, &value); err != nil {
+				return err
+			}
+			info[key] = json.RawMessage(value)
+			return nil
+		})
+		if err != nil {
+			sendError(w, "exception", "Error reading build metadata")
+			return
+		}
+
+		// The build metadata is not a FHIR resource
+		w.Header().Set("Content-Type", plainJSON+"; charset=utf-8")
+		sendResource(w, info)
+	})
+}
@@ -0,0 +1,84 @@
+package fhir_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/mattwiller/hawthorn/internal/fhir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfo(t *testing.T) {
+	require := require.New(t)
+
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(err)
+	srv := fhir.InfoHandler(db)
+
+	req := httptest.NewRequest("GET", "/_info", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(200, res.Result().StatusCode)
+	require.Equal("application/json; charset=utf-8", res.Result().Header.Get("Content-Type"))
+
+	var info struct {
+		UmlsRelease    struct{ Name string }
+		BuildTime      string
+		HawthornCommit string
+		Languages      []string
+		Sources        []struct {
+			Sab, Url, Version string
+			Tty               []string
+			Translations      []struct{ Sab, Version, Language string }
+		}
+	}
+	require.NoError(json.NewDecoder(res.Result().Body).Decode(&info))
+	require.Equal("2023AB", info.UmlsRelease.Name)
+	require.NotEmpty(info.BuildTime)
+	require.Contains(info.Languages, "en")
+
+	var snomed bool
+	for _, source := range info.Sources {
+		if source.Sab == "SNOMEDCT_US" {
+			snomed = true
+			require.Equal("http://snomed.info/sct", source.Url)
+			require.Equal("http://snomed.info/sct/731000124108/version/20230901", source.Version)
+			require.Equal([]string{"FN", "PT", "SY"}, source.Tty)
+		}
+	}
+	require.True(snomed, "SNOMEDCT_US should be listed in sources")
+}
+
+func TestInfoCodeSystemVersion(t *testing.T) {
+	require := require.New(t)
+
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(err)
+	srv := fhir.MetadataHandler(db, fhir.Operations)
+
+	req := httptest.NewRequest("GET", "/R4/metadata?mode=terminology", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(200, res.Result().StatusCode)
+
+	var capabilities struct {
+		CodeSystem []struct {
+			Uri     string
+			Version []struct{ Code string }
+		}
+	}
+	require.NoError(json.NewDecoder(res.Result().Body).Decode(&capabilities))
+	versions := make(map[string]string)
+	for _, codeSystem := range capabilities.CodeSystem {
+		if len(codeSystem.Version) > 0 {
+			versions[codeSystem.Uri] = codeSystem.Version[0].Code
+		}
+	}
+	// Versions are those of the sources in the release rather than the CodeSystem definitions, in FHIR format
+	require.Equal("http://snomed.info/sct/731000124108/version/20230901", versions["http://snomed.info/sct"])
+	require.Equal("09052023", versions["http://www.nlm.nih.gov/research/umls/rxnorm"])
+}
@@ -0,0 +1,86 @@
+package fhir
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Determines the languages to display codes in, from the displayLanguage parameter or else the Accept-Language header,
+// in order of preference. Since the display of each code is in English, languages preferred less than English are
+// omitted, and an empty list means the English display should be used.
+// @see http://hl7.org/fhir/R4/languages.html
+func displayLanguages(input *operationInput, r *http.Request) []string {
+	var requested []string
+	if input.Has("displayLanguage") {
+		requested = []string{input.Get("displayLanguage")}
+	} else {
+		requested = acceptedLanguages(r.Header.Get("Accept-Language"))
+	}
+
+	var languages []string
+	for _, tag := range requested {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		primary, _, _ := strings.Cut(tag, "-")
+		if primary == "en" {
+			break
+		}
+		// Designations are stored by their primary language, so a regional variant (e.g. es-MX) also matches it
+		for _, language := range []string{tag, primary} {
+			if language != "" && !slices.Contains(languages, language) {
+				languages = append(languages, language)
+			}
+		}
+	}
+	return languages
+}
+
+// Parses the language tags of an Accept-Language header, in order of their quality values. Wildcards and languages
+// which are not acceptable (q=0) are omitted.
+// @see https://www.rfc-editor.org/rfc/rfc9110#name-accept-language
+func acceptedLanguages(header string) []string {
+	type accepted struct {
+		tag     string
+		quality float64
+	}
+	var languages []accepted
+	for _, value := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(value, ";")
+		tag = strings.TrimSpace(tag)
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			quality, _ = strconv.ParseFloat(q, 64)
+		}
+		if tag != "" && tag != "*" && quality > 0 {
+			languages = append(languages, accepted{tag, quality})
+		}
+	}
+	slices.SortStableFunc(languages, func(a, b accepted) int {
+		switch {
+		case a.quality > b.quality:
+			return -1
+		case a.quality < b.quality:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	tags := make([]string, len(languages))
+	for i, language := range languages {
+		tags[i] = language.tag
+	}
+	return tags
+}
+
+// Returns an SQL expression for the display of a code in the most preferred of the languages given as a JSON array by
+// the numbered query parameter, falling back to the (English) display of the code. Designations in each language are
+// stored in order of preference, so the first is used.
+func localizedDisplaySQL(param int) string {
+	return fmt.Sprintf(`coalesce((SELECT "Coding_Designation".value FROM json_each($%d) "Language"
+		JOIN "Coding_Designation" ON "Coding_Designation".language = "Language".value
+		WHERE "Coding_Designation".coding = "Coding".id
+		ORDER BY "Language".key, "Coding_Designation".id LIMIT 1), "Coding".display)`, param)
+}
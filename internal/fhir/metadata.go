@@ -0,0 +1,216 @@
+package fhir
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+const fhirVersion = "4.0.1"
+
+// An operation endpoint provided by the server, which is advertised in its CapabilityStatement.
+type Operation struct {
+	// Resource type the operation is invoked on, e.g. CodeSystem
+	Resource string
+	// Operation name, without the leading '$'
+	Name string
+	// Canonical URL of the OperationDefinition
+	Definition string
+	Handler    func(db *internal.DB) http.HandlerFunc
+}
+
+// Path of the operation endpoint, relative to the FHIR base URL.
+func (op Operation) Path() string {
+	return "/" + op.Resource + "/$" + op.Name
+}
+
+// All operations supported by the server.
+var Operations = []Operation{
+	{"CodeSystem", "lookup", "http://hl7.org/fhir/OperationDefinition/CodeSystem-lookup", CodeSystemLookupHandler},
+	{"CodeSystem", "validate-code", "http://hl7.org/fhir/OperationDefinition/CodeSystem-validate-code", CodeSystemValidateCodeHandler},
+	{"CodeSystem", "subsumes", "http://hl7.org/fhir/OperationDefinition/CodeSystem-subsumes", CodeSystemSubsumesHandler},
+	{"ValueSet", "expand", "http://hl7.org/fhir/OperationDefinition/ValueSet-expand", ValueSetExpandHandler},
+	{"ValueSet", "validate-code", "http://hl7.org/fhir/OperationDefinition/ValueSet-validate-code", ValueSetValidateCodeHandler},
+	{"ConceptMap", "translate", "http://hl7.org/fhir/OperationDefinition/ConceptMap-translate", ConceptMapTranslateHandler},
+}
+
+// Implements the capabilities interaction, describing the given operations in a CapabilityStatement, or the loaded
+// code systems in a TerminologyCapabilities resource when called with mode=terminology.
+// @see http://hl7.org/fhir/R4/http.html#capabilities
+func MetadataHandler(db *internal.DB, operations []Operation) http.HandlerFunc {
+	started := time.Now().UTC().Format(time.RFC3339)
+	return handleRequest(db, []string{http.MethodGet}, func(db *internal.DB, w http.ResponseWriter, r *http.Request) {
+		input, err := readInput(r)
+		if err != nil {
+			sendError(w, "invalid", err.Error())
+			return
+		}
+
+		base := strings.TrimSuffix(r.URL.Path, "/metadata")
+		resource := map[string]any{
+			"status": "active",
+			"date":   started,
+			"kind":   "instance",
+			"software": map[string]any{
+				"name": "Hawthorn",
+			},
+			"implementation": map[string]any{
+				"description": "Hawthorn terminology service",
+				"url":         requestBaseURL(r) + base,
+			},
+		}
+
+		switch input.Get("mode") {
+		case "", "full":
+			resource["resourceType"] = "CapabilityStatement"
+			resource["fhirVersion"] = fhirVersion
+			resource["format"] = []string{"json"}
+			resource["rest"] = []map[string]any{{
+				"mode":     "server",
+				"resource": formatResourceOperations(operations),
+			}}
+		case "terminology":
+			codeSystems, err := terminologyCodeSystems(db)
+			if err != nil {
+				sendError(w, "exception", "Error finding code systems")
+				return
+			}
+			resource["resourceType"] = "TerminologyCapabilities"
+			resource["codeSystem"] = codeSystems
+			resource["expansion"] = map[string]any{
+				"hierarchical": false,
+				"paging":       true,
+				"parameter": []map[string]any{
+					{"name": "filter"}, {"name": "offset"}, {"name": "count"}, {"name": "activeOnly"},
+				},
+				"textFilter": "Matches codes with a display containing a word starting with each word of the filter",
+			}
+			resource["validateCode"] = map[string]any{"translations": false}
+		default:
+			sendError(w, "not-supported", "Unsupported capabilities mode '"+input.Get("mode")+"'")
+			return
+		}
+
+		sendResource(w, resource)
+	})
+}
+
+// Describes the interactions and operations supported for each resource type: the stored resource types can be read
+// and searched, and operations are grouped by the resource type they are invoked on.
+func formatResourceOperations(operations []Operation) []map[string]any {
+	var resources []map[string]any
+	byType := make(map[string]map[string]any)
+	for _, resourceType := range ResourceTypes {
+		resource := map[string]any{
+			"type":        resourceType,
+			"interaction": []map[string]any{{"code": "read"}, {"code": "search-type"}},
+			"searchParam": []map[string]any{
+				{"name": "_id", "type": "token"},
+				{"name": "url", "type": "uri"},
+				{"name": "version", "type": "token"},
+				{"name": "name", "type": "string"},
+			},
+			"operation": []map[string]any{},
+		}
+		byType[resourceType] = resource
+		resources = append(resources, resource)
+	}
+	for _, op := range operations {
+		resource, ok := byType[op.Resource]
+		if !ok {
+			resource = map[string]any{"type": op.Resource, "operation": []map[string]any{}}
+			byType[op.Resource] = resource
+			resources = append(resources, resource)
+		}
+		resource["operation"] = append(resource["operation"].([]map[string]any), map[string]any{
+			"name":       op.Name,
+			"definition": op.Definition,
+		})
+	}
+	return resources
+}
+
+// Describes each code system in the database, for TerminologyCapabilities. The versions of each code system in the
+// databases of other releases attached to it are listed after its default version.
+func terminologyCodeSystems(db *internal.DB) ([]map[string]any, error) {
+	codeSystems, err := describeCodeSystems(db)
+	if err != nil {
+		return nil, err
+	}
+	byURL := make(map[string]map[string]any, len(codeSystems))
+	for _, codeSystem := range codeSystems {
+		byURL[codeSystem["uri"].(string)] = codeSystem
+	}
+	for _, release := range db.Releases() {
+		versions, err := describeCodeSystems(release)
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			codeSystem, ok := byURL[version["uri"].(string)]
+			if !ok {
+				codeSystems = append(codeSystems, version)
+				byURL[version["uri"].(string)] = version
+				continue
+			}
+			v, ok := version["version"].([]map[string]any)
+			existing, _ := codeSystem["version"].([]map[string]any)
+			if ok && !slices.ContainsFunc(existing, func(e map[string]any) bool { return e["code"] == v[0]["code"] }) {
+				v[0]["isDefault"] = false
+				codeSystem["version"] = append(existing, v[0])
+			}
+		}
+	}
+	return codeSystems, nil
+}
+
+// Describes each code system in a single database.
+func describeCodeSystems(db *internal.DB) ([]map[string]any, error) {
+	codeSystems := []map[string]any{}
+	err := db.Prep(`SELECT url, json_extract(CAST(json AS TEXT), '$.version'), json_extract(CAST(json AS TEXT), '$.content'),
+		json_extract(CAST(json AS TEXT), '$.hierarchyMeaning'), EXISTS (SELECT 1 FROM "Coding" WHERE system = "CodeSystem".id)
+		FROM "CodeSystem" ORDER BY url`).Each(func(rows *internal.Rows) error {
+		var url, version, content, hierarchyMeaning string
+		var loaded bool
+		if err := rows.Scan(&url, &version, &content, &hierarchyMeaning, &loaded); err != nil {
+			return err
+		}
+		// The stored CodeSystem resources do not include their concepts, but all codes from the release are loaded
+		if loaded && content == "not-present" {
+			content = "complete"
+		}
+
+		codeSystem := map[string]any{
+			"uri":         url,
+			"subsumption": hierarchyMeaning == "is-a",
+		}
+		if version != "" {
+			codeSystem["version"] = []map[string]any{{"code": version, "isDefault": true}}
+		}
+		if content != "" {
+			// CodeSystem content was only added to TerminologyCapabilities in R5
+			codeSystem["extension"] = []map[string]any{{
+				"url":       "http://hl7.org/fhir/5.0/StructureDefinition/extension-TerminologyCapabilities.codeSystem.content",
+				"valueCode": content,
+			}}
+		}
+		codeSystems = append(codeSystems, codeSystem)
+		return nil
+	})
+	return codeSystems, err
+}
+
+// Reconstructs the scheme and host the client used to make the request, including through a reverse proxy.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,88 @@
+package fhir_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/mattwiller/hawthorn/internal/fhir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	require := require.New(t)
+
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(err)
+	srv := fhir.MetadataHandler(db, fhir.Operations)
+
+	req := httptest.NewRequest("GET", "/R4/metadata", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(200, res.Result().StatusCode)
+
+	var capabilities struct {
+		ResourceType   string
+		FhirVersion    string
+		Implementation struct{ Url string }
+		Rest           []struct {
+			Resource []struct {
+				Type      string
+				Operation []struct{ Name, Definition string }
+			}
+		}
+	}
+	require.NoError(json.NewDecoder(res.Result().Body).Decode(&capabilities))
+	require.Equal("CapabilityStatement", capabilities.ResourceType)
+	require.Equal("4.0.1", capabilities.FhirVersion)
+	require.Equal("http://example.com/R4", capabilities.Implementation.Url)
+
+	require.Len(capabilities.Rest, 1)
+	operations := make(map[string]string)
+	for _, resource := range capabilities.Rest[0].Resource {
+		for _, op := range resource.Operation {
+			operations[resource.Type+"/$"+op.Name] = op.Definition
+		}
+	}
+	require.Equal(map[string]string{
+		"CodeSystem/$lookup":        "http://hl7.org/fhir/OperationDefinition/CodeSystem-lookup",
+		"CodeSystem/$validate-code": "http://hl7.org/fhir/OperationDefinition/CodeSystem-validate-code",
+		"CodeSystem/$subsumes":      "http://hl7.org/fhir/OperationDefinition/CodeSystem-subsumes",
+		"ValueSet/$expand":          "http://hl7.org/fhir/OperationDefinition/ValueSet-expand",
+		"ValueSet/$validate-code":   "http://hl7.org/fhir/OperationDefinition/ValueSet-validate-code",
+		"ConceptMap/$translate":     "http://hl7.org/fhir/OperationDefinition/ConceptMap-translate",
+	}, operations)
+}
+
+func TestMetadataTerminology(t *testing.T) {
+	require := require.New(t)
+
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(err)
+	srv := fhir.MetadataHandler(db, fhir.Operations)
+
+	req := httptest.NewRequest("GET", "/R4/metadata?mode=terminology", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(200, res.Result().StatusCode)
+
+	var capabilities struct {
+		ResourceType string
+		CodeSystem   []struct {
+			Uri         string
+			Subsumption bool
+		}
+	}
+	require.NoError(json.NewDecoder(res.Result().Body).Decode(&capabilities))
+	require.Equal("TerminologyCapabilities", capabilities.ResourceType)
+
+	subsumption := make(map[string]bool)
+	for _, codeSystem := range capabilities.CodeSystem {
+		subsumption[codeSystem.Uri] = codeSystem.Subsumption
+	}
+	require.Contains(subsumption, "http://loinc.org")
+	require.True(subsumption["http://snomed.info/sct"])
+}
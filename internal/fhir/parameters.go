@@ -0,0 +1,123 @@
+package fhir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Represents a FHIR Coding data type.
+// @see http://hl7.org/fhir/R4/datatypes.html#Coding
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Version string `json:"version,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// Represents a FHIR CodeableConcept data type.
+// @see http://hl7.org/fhir/R4/datatypes.html#CodeableConcept
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+// Input parameters for an operation, read either from the URL query string or from a Parameters resource sent as the
+// request body.
+// @see http://hl7.org/fhir/R4/operations.html#request
+type operationInput struct {
+	values   url.Values
+	codings  map[string][]Coding
+	concepts map[string][]CodeableConcept
+}
+
+func readInput(r *http.Request) (*operationInput, error) {
+	input := &operationInput{
+		values:   r.URL.Query(),
+		codings:  make(map[string][]Coding),
+		concepts: make(map[string][]CodeableConcept),
+	}
+	if r.Method != http.MethodPost {
+		return input, nil
+	}
+
+	var body struct {
+		ResourceType string                       `json:"resourceType"`
+		Parameter    []map[string]json.RawMessage `json:"parameter"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("request body must be a Parameters resource: %w", err)
+	} else if body.ResourceType != "Parameters" {
+		return nil, errors.New("request body must be a Parameters resource, got " + body.ResourceType)
+	}
+
+	for _, param := range body.Parameter {
+		var name string
+		if err := json.Unmarshal(param["name"], &name); err != nil || name == "" {
+			return nil, errors.New("parameter is missing name")
+		}
+		for key, value := range param {
+			if !strings.HasPrefix(key, "value") {
+				continue
+			}
+
+			switch key {
+			case "valueCoding":
+				var coding Coding
+				if err := json.Unmarshal(value, &coding); err != nil {
+					return nil, fmt.Errorf("invalid Coding for parameter %s: %w", name, err)
+				}
+				input.codings[name] = append(input.codings[name], coding)
+			case "valueCodeableConcept":
+				var concept CodeableConcept
+				if err := json.Unmarshal(value, &concept); err != nil {
+					return nil, fmt.Errorf("invalid CodeableConcept for parameter %s: %w", name, err)
+				}
+				input.concepts[name] = append(input.concepts[name], concept)
+			default:
+				var primitive any
+				if err := json.Unmarshal(value, &primitive); err != nil {
+					return nil, fmt.Errorf("invalid value for parameter %s: %w", name, err)
+				}
+				switch v := primitive.(type) {
+				case string:
+					input.values.Add(name, v)
+				case bool, float64:
+					input.values.Add(name, string(value))
+				default:
+					return nil, fmt.Errorf("unsupported value type %s for parameter %s", key, name)
+				}
+			}
+		}
+	}
+	return input, nil
+}
+
+func (input *operationInput) Has(name string) bool {
+	return input.values.Has(name)
+}
+
+func (input *operationInput) Get(name string) string {
+	return input.values.Get(name)
+}
+
+func (input *operationInput) Values(name string) []string {
+	return input.values[name]
+}
+
+func (input *operationInput) Coding(name string) *Coding {
+	if codings := input.codings[name]; len(codings) > 0 {
+		return &codings[0]
+	}
+	return nil
+}
+
+func (input *operationInput) CodeableConcept(name string) *CodeableConcept {
+	if concepts := input.concepts[name]; len(concepts) > 0 {
+		return &concepts[0]
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+// Resource types which can be read and searched, each stored as JSON in the table of the same name.
+var ResourceTypes = []string{"CodeSystem", "ValueSet", "ConceptMap"}
+
+// Search parameters supported for all resource types, mapped to the SQL expression they match against.
+var searchParams = map[string]string{
+	"_id":     `_id`,
+	"url":     `url`,
+	"version": `json_extract(CAST(json AS TEXT), '$.version')`,
+	"name":    `json_extract(CAST(json AS TEXT), '$.name')`,
+}
+
+// Implements the read interaction for a stored resource type, e.g. GET /CodeSystem/{id}.
+// @see http://hl7.org/fhir/R4/http.html#read
+func ReadHandler(db *internal.DB, resourceType string) http.HandlerFunc {
+	return handleRequest(db, []string{http.MethodGet}, func(db *internal.DB, w http.ResponseWriter, r *http.Request) {
+		_, id, _ := strings.Cut(r.URL.Path, "/"+resourceType+"/")
+		if id == "" || strings.Contains(id, "/") {
+			sendError(w, "not-found", "Unknown path "+r.URL.Path)
+			return
+		}
+
+		var raw []byte
+		found, err := db.Prep(`SELECT json FROM "`+resourceType+`" WHERE _id = $1 ORDER BY id DESC LIMIT 1`, id).First(&raw)
+		if err != nil {
+			sendError(w, "exception", "Error reading resource")
+			return
+		} else if !found {
+			sendError(w, "not-found", fmt.Sprintf("%s/%s not found", resourceType, id))
+			return
+		}
+
+		resource, err := parseResource(id, raw)
+		if err != nil {
+			sendError(w, "exception", "Error reading resource")
+			return
+		}
+		sendResource(w, resource)
+	})
+}
+
+// Implements the search interaction for a stored resource type, e.g. GET /CodeSystem?url=http://loinc.org, supporting
+// the _id, url, version and name parameters. Other parameters are ignored, and omitted from the Bundle self link.
+// @see http://hl7.org/fhir/R4/http.html#search
+func SearchHandler(db *internal.DB, resourceType string) http.HandlerFunc {
+	return handleRequest(db, []string{http.MethodGet}, func(db *internal.DB, w http.ResponseWriter, r *http.Request) {
+		query := `SELECT _id, json FROM "` + resourceType + `"`
+		var conditions []string
+		var args []any
+		applied := url.Values{}
+		for name, values := range r.URL.Query() {
+			column, ok := searchParams[name]
+			if !ok {
+				continue
+			}
+			for _, value := range values {
+				applied.Add(name, value)
+				args = append(args, value)
+				if name == "name" {
+					// String search parameters match case-insensitively at the start of the value
+					args[len(args)-1] = escapeLike(value) + "%"
+					conditions = append(conditions, fmt.Sprintf(`%s LIKE $%d ESCAPE '\'`, column, len(args)))
+				} else {
+					conditions = append(conditions, fmt.Sprintf(`%s = $%d`, column, len(args)))
+				}
+			}
+		}
+		if len(conditions) > 0 {
+			query += ` WHERE ` + strings.Join(conditions, ` AND `)
+		}
+		query += ` ORDER BY url, id`
+
+		baseURL := requestBaseURL(r) + strings.TrimSuffix(r.URL.Path, "/"+resourceType)
+		entries := []map[string]any{}
+		err := db.PrepTransient(query, args...).Each(func(rows *internal.Rows) error {
+			var id string
+			var raw []byte
+			if err := rows.Scan(&id, &raw); err != nil {
+				return err
+			}
+
+			resource, err := parseResource(id, raw)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, map[string]any{
+				"fullUrl":  baseURL + "/" + resourceType + "/" + id,
+				"resource": resource,
+				"search":   map[string]any{"mode": "match"},
+			})
+			return nil
+		})
+		if err != nil {
+			sendError(w, "exception", "Error searching resources")
+			return
+		}
+
+		self := baseURL + "/" + resourceType
+		if len(applied) > 0 {
+			self += "?" + applied.Encode()
+		}
+		sendResource(w, map[string]any{
+			"resourceType": "Bundle",
+			"type":         "searchset",
+			"total":        len(entries),
+			"link":         []map[string]any{{"relation": "self", "url": self}},
+			"entry":        entries,
+		})
+	})
+}
+
+// Parses a stored resource, setting its logical ID to the one it is stored under.
+func parseResource(id string, raw []byte) (map[string]any, error) {
+	var resource map[string]any
+	if err := json.Unmarshal(raw, &resource); err != nil {
+		return nil, err
+	}
+	resource["id"] = id
+	return resource, nil
+}
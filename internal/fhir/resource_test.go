@@ -0,0 +1,95 @@
+package fhir_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/mattwiller/hawthorn/internal/fhir"
+	"github.com/stretchr/testify/require"
+)
+
+const loincID = "8900a26d-57bd-5f24-81d7-452864d67a69"
+
+func TestCodeSystemRead(t *testing.T) {
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(t, err)
+	srv := fhir.ReadHandler(db, "CodeSystem")
+
+	t.Run("found", func(t *testing.T) {
+		require := require.New(t)
+
+		req := httptest.NewRequest("GET", "/R4/CodeSystem/"+loincID, nil)
+		res := httptest.NewRecorder()
+		srv.ServeHTTP(res, req)
+
+		require.Equal(200, res.Result().StatusCode)
+
+		var resource struct {
+			ResourceType string
+			Id           string
+			Url          string
+			Property     []struct{ Code string }
+		}
+		require.NoError(json.NewDecoder(res.Result().Body).Decode(&resource))
+		require.Equal("CodeSystem", resource.ResourceType)
+		require.Equal(loincID, resource.Id)
+		require.Equal("http://loinc.org", resource.Url)
+		require.NotEmpty(resource.Property)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/R4/CodeSystem/unknown", nil)
+		res := httptest.NewRecorder()
+		srv.ServeHTTP(res, req)
+
+		require.Equal(t, 404, res.Result().StatusCode)
+	})
+}
+
+func TestCodeSystemSearch(t *testing.T) {
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(t, err)
+	srv := fhir.SearchHandler(db, "CodeSystem")
+
+	tests := []struct {
+		name     string
+		url      string
+		expected []string
+	}{
+		{name: "url", url: "/R4/CodeSystem?url=http://loinc.org", expected: []string{"http://localhost/R4/CodeSystem/" + loincID}},
+		{name: "name prefix", url: "/R4/CodeSystem?name=LOIN", expected: []string{"http://localhost/R4/CodeSystem/" + loincID}},
+		{name: "no match", url: "/R4/CodeSystem?url=http://loinc.org&version=0", expected: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			req := httptest.NewRequest("GET", test.url, nil)
+			req.Host = "localhost"
+			res := httptest.NewRecorder()
+			srv.ServeHTTP(res, req)
+
+			require.Equal(200, res.Result().StatusCode)
+
+			var bundle struct {
+				ResourceType string
+				Type         string
+				Total        int
+				Entry        []struct{ FullUrl string }
+			}
+			require.NoError(json.NewDecoder(res.Result().Body).Decode(&bundle))
+			require.Equal("Bundle", bundle.ResourceType)
+			require.Equal("searchset", bundle.Type)
+			require.Equal(len(test.expected), bundle.Total)
+
+			var urls []string
+			for _, entry := range bundle.Entry {
+				urls = append(urls, entry.FullUrl)
+			}
+			require.Equal(test.expected, urls)
+		})
+	}
+}
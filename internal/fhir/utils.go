@@ -3,18 +3,182 @@ package fhir
 import (
 	"encoding/json"
 	"fmt"
+	"log"
+	"mime"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+const (
+	fhirJSON  = "application/fhir+json"
+	plainJSON = "application/json"
 )
 
+// A request handler, called with a database connection held for the duration of the request.
+type operationHandler func(db *internal.DB, w http.ResponseWriter, r *http.Request)
+
+// Wraps an operation handler, which may be invoked with either GET or POST.
+func handleOperation(db *internal.DB, handler operationHandler) http.HandlerFunc {
+	return handleRequest(db, []string{http.MethodGet, http.MethodPost}, handler)
+}
+
+// Wraps a request handler with the behavior common to all FHIR endpoints: restricting request methods, negotiating
+// the response format, checking the request body format, acquiring a database connection, and converting panics into an
+// error response.
+// @see http://hl7.org/fhir/R4/http.html#mime-type
+func handleRequest(db *internal.DB, methods []string, handler operationHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", fhirJSON+"; charset=utf-8")
+		if !slices.Contains(methods, r.Method) {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			writeOutcome(w, http.StatusMethodNotAllowed, "not-supported", "Method "+r.Method+" is not supported")
+			return
+		}
+
+		format, ok := negotiateFormat(r)
+		if !ok {
+			writeOutcome(w, http.StatusNotAcceptable, "not-supported", "Only JSON responses are supported")
+			return
+		}
+		w.Header().Set("Content-Type", format+"; charset=utf-8")
+
+		if r.Method == http.MethodPost && r.Header.Get("Content-Type") != "" {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || !isJSON(mediaType) {
+				writeOutcome(w, http.StatusUnsupportedMediaType, "not-supported", "Request body must be JSON")
+				return
+			}
+		}
+
+		conn, err := db.Acquire(r.Context())
+		if err != nil {
+			writeOutcome(w, http.StatusServiceUnavailable, "transient", "Database connection unavailable")
+			return
+		}
+		defer conn.Release()
+
+		// Once the handler has started the response, a panic can only be logged: writing an error would append it to
+		// the partial output
+		tw := &trackingWriter{ResponseWriter: w}
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("panic handling %s %s: %v", r.Method, r.URL.Path, err)
+				if !tw.wroteHeader {
+					writeOutcome(w, http.StatusInternalServerError, "exception", "Internal server error")
+				}
+			}
+		}()
+		handler(conn, tw, r)
+	}
+}
+
+// Records whether the response status has been sent, explicitly or by writing the body.
+type trackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *trackingWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trackingWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *trackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Determines the response media type from the _format parameter or the Accept header, returning false if the client
+// does not accept any JSON format.
+func negotiateFormat(r *http.Request) (string, bool) {
+	if format := r.URL.Query().Get("_format"); format != "" {
+		switch format {
+		case "json", fhirJSON:
+			return fhirJSON, true
+		case plainJSON:
+			return plainJSON, true
+		default:
+			return "", false
+		}
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return fhirJSON, true
+	}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		} else if q, ok := params["q"]; ok {
+			// Media ranges with a quality of zero (e.g. q=0.0) are not acceptable
+			if quality, err := strconv.ParseFloat(q, 64); err != nil || quality <= 0 {
+				continue
+			}
+		}
+		switch mediaType {
+		case fhirJSON, "application/json+fhir", "application/*", "*/*":
+			return fhirJSON, true
+		case plainJSON:
+			return plainJSON, true
+		}
+	}
+	return "", false
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == plainJSON || mediaType == fhirJSON || strings.HasSuffix(mediaType, "+json")
+}
+
+// Sends an OperationOutcome describing an error, with the HTTP status corresponding to the issue type.
+// @see http://hl7.org/fhir/R4/valueset-issue-type.html
 func sendError(w http.ResponseWriter, code string, details string) {
-	w.Write([]byte(fmt.Sprintf(`{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"%s","details":{"text":"%s"}}]}`, code, details)))
+	status := http.StatusInternalServerError
+	switch code {
+	case "invalid", "structure", "required", "value":
+		status = http.StatusBadRequest
+	case "not-found":
+		status = http.StatusNotFound
+	case "processing", "business-rule", "too-costly", "not-supported":
+		status = http.StatusUnprocessableEntity
+	}
+	writeOutcome(w, status, code, details)
+}
+
+func writeOutcome(w http.ResponseWriter, status int, code string, details string) {
+	output, err := json.Marshal(map[string]any{
+		"resourceType": "OperationOutcome",
+		"issue": []map[string]any{
+			{"severity": "error", "code": code, "details": map[string]any{"text": details}},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	w.WriteHeader(status)
+	w.Write(output)
 }
 
 func sendOutput(w http.ResponseWriter, parameters []map[string]any) {
 	w.Write([]byte(formatParameters(parameters)))
 }
 
+func sendResource(w http.ResponseWriter, resource any) {
+	output, err := json.Marshal(resource)
+	if err != nil {
+		panic(err)
+	}
+	w.Write(output)
+}
+
 func formatParameters(parameters []map[string]any) string {
 	output, err := json.Marshal(parameters)
 	if err != nil {
@@ -23,6 +187,11 @@ func formatParameters(parameters []map[string]any) string {
 	return fmt.Sprintf(`{"resourceType":"Parameters","parameter":%s}`, output)
 }
 
+// Escapes the special characters in a string for use in a LIKE pattern with '\' as the escape character.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
 func capitalize(s string) string {
 	if len(s) < 1 {
 		return ""
@@ -0,0 +1,128 @@
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+type valueSet struct {
+	id       int64
+	url      string
+	version  string
+	resource map[string]any
+
+	// ----- Implicit value sets -----
+	// Code system containing all codes in the value set
+	system *codeSystem
+	// Optional root concept, for value sets containing only a subtree of the code system hierarchy
+	ancestor *coding
+}
+
+// Finds a stored value set by its canonical URL and (optional) version, returning nil if it does not exist. When no
+// version is given, the most recently loaded value set with the URL is returned.
+func findValueSet(db *internal.DB, url string, version string) (*valueSet, error) {
+	vs := new(valueSet)
+	var resource []byte
+	found, err := db.Prep(`SELECT id, url, json, json_extract(CAST(json AS TEXT), '$.version')
+		FROM "ValueSet" WHERE url = $1 AND ($2 = '' OR json_extract(CAST(json AS TEXT), '$.version') = $2)
+		ORDER BY id DESC LIMIT 1`, url, version).First(&vs.id, &vs.url, &resource, &vs.version)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(resource, &vs.resource); err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// Resolves an implicit value set defined by a code system: either all codes in the system (e.g.
+// "http://snomed.info/sct?fhir_vs", or the CodeSystem.valueSet URL like "http://loinc.org/vs"), or all codes subsumed
+// by a given concept ("http://snomed.info/sct?fhir_vs=isa/73211009"). Returns nil if the URL is not such a value set.
+// @see http://hl7.org/fhir/R4/snomedct.html#implicit
+func findImplicitValueSet(db *internal.DB, url string) (*valueSet, error) {
+	var system *codeSystem
+	var ancestor *coding
+	var err error
+	if base, query, ok := strings.Cut(url, "?"); ok {
+		if system, err = findCodeSystem(db, base); err != nil || system == nil {
+			return nil, err
+		}
+
+		switch {
+		case query == "fhir_vs":
+			// All codes in the system
+		case strings.HasPrefix(query, "fhir_vs=isa/"):
+			if ancestor, err = findCoding(db, system, strings.TrimPrefix(query, "fhir_vs=isa/")); err != nil || ancestor == nil {
+				return nil, err
+			}
+		default:
+			return nil, nil
+		}
+	} else {
+		var systemURL string
+		found, err := db.Prep(`SELECT url FROM "CodeSystem" WHERE json_extract(CAST(json AS TEXT), '$.valueSet') = $1`, url).First(&systemURL)
+		if err != nil || !found {
+			return nil, err
+		}
+		if system, err = findCodeSystem(db, systemURL); err != nil || system == nil {
+			return nil, err
+		}
+	}
+
+	include := map[string]any{"system": system.url}
+	if system.version != "" {
+		include["version"] = system.version
+	}
+	if ancestor != nil {
+		include["filter"] = []map[string]any{
+			{"property": "concept", "op": "is-a", "value": ancestor.code},
+		}
+	}
+	return &valueSet{
+		url:     url,
+		version: system.version,
+		resource: map[string]any{
+			"resourceType": "ValueSet",
+			"url":          url,
+			"status":       "active",
+			"compose":      map[string]any{"include": []map[string]any{include}},
+		},
+		system:   system,
+		ancestor: ancestor,
+	}, nil
+}
+
+// Determines whether the given coding is a member of the value set.
+func valueSetContains(db *internal.DB, vs *valueSet, c *coding) (bool, error) {
+	from, where, args := valueSetCodings(vs)
+	args = append(args, c.id)
+	where = append(where, fmt.Sprintf(`"Coding".id = $%d`, len(args)))
+	results, err := db.Query(`SELECT "Coding".id `+from+` WHERE `+strings.Join(where, " AND ")+` LIMIT 1`, args...)
+	if err != nil {
+		return false, err
+	}
+	return len(results) > 0, nil
+}
+
+// Builds the SQL FROM and WHERE clauses (with their arguments) selecting the "Coding" rows in the value set.
+func valueSetCodings(vs *valueSet) (string, []string, []any) {
+	if vs.system == nil {
+		return `FROM "ValueSet_Membership" "Member"
+			JOIN "Coding" ON "Coding".id = "Member".coding
+			JOIN "CodeSystem" ON "CodeSystem".id = "Coding".system`,
+			[]string{`"Member"."valueSet" = $1`}, []any{vs.id}
+	}
+
+	from := `FROM "Coding" JOIN "CodeSystem" ON "CodeSystem".id = "Coding".system`
+	if vs.ancestor != nil {
+		return from, []string{
+			`"Coding".system = $1`,
+			`("Coding".id = $2 OR "Coding".id IN (SELECT descendant FROM "Coding_Closure" WHERE ancestor = $2))`,
+		}, []any{vs.system.id, vs.ancestor.id}
+	}
+	return from, []string{`"Coding".system = $1`}, []any{vs.system.id}
+}
@@ -0,0 +1,227 @@
+package fhir
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+// Maximum number of codes returned in a single page of a value set expansion.
+const maxExpansionCount = 1000
+
+type expansionParams struct {
+	filter              string
+	offset              int
+	count               int
+	activeOnly          bool
+	includeDesignations bool
+	displayLanguage     string
+	// Languages to display codes in, in order of preference, falling back to English
+	languages []string
+}
+
+// Implements the ValueSet/$expand operation endpoint.
+// @see http://hl7.org/fhir/R4/valueset-operation-expand.html
+func ValueSetExpandHandler(db *internal.DB) http.HandlerFunc {
+	return handleOperation(db, func(db *internal.DB, w http.ResponseWriter, r *http.Request) {
+		input, err := readInput(r)
+		if err != nil {
+			sendError(w, "invalid", err.Error())
+			return
+		}
+
+		if !input.Has("url") {
+			sendError(w, "required", "Value set must be specified using the 'url' parameter")
+			return
+		}
+		url, version, _ := strings.Cut(input.Get("url"), "|")
+		if input.Has("valueSetVersion") {
+			version = input.Get("valueSetVersion")
+		}
+
+		params, err := readExpansionParams(input)
+		if err != nil {
+			sendError(w, "invalid", err.Error())
+			return
+		}
+		params.languages = displayLanguages(input, r)
+
+		vs, err := findValueSet(db, url, version)
+		if err == nil && vs == nil {
+			vs, err = findImplicitValueSet(db, url)
+		}
+		if err != nil {
+			sendError(w, "exception", "Error finding value set")
+			return
+		} else if vs == nil {
+			sendError(w, "not-found", "Value set not found")
+			return
+		}
+
+		total, contains, err := expandMembers(db, vs, params)
+		if err != nil {
+			sendError(w, "exception", "Error expanding value set")
+			return
+		}
+
+		vs.resource["expansion"] = formatExpansion(params, total, contains)
+		sendResource(w, vs.resource)
+	})
+}
+
+func readExpansionParams(input *operationInput) (expansionParams, error) {
+	params := expansionParams{
+		filter:              input.Get("filter"),
+		count:               maxExpansionCount,
+		activeOnly:          input.Get("activeOnly") == "true",
+		includeDesignations: input.Get("includeDesignations") == "true",
+		displayLanguage:     input.Get("displayLanguage"),
+	}
+	if input.Has("offset") {
+		offset, err := strconv.Atoi(input.Get("offset"))
+		if err != nil || offset < 0 {
+			return params, fmt.Errorf("invalid offset '%s'", input.Get("offset"))
+		}
+		params.offset = offset
+	}
+	if input.Has("count") {
+		count, err := strconv.Atoi(input.Get("count"))
+		if err != nil || count < 0 {
+			return params, fmt.Errorf("invalid count '%s'", input.Get("count"))
+		}
+		params.count = min(count, maxExpansionCount)
+	}
+	return params, nil
+}
+
+// Expands the value set, returning the total number of matching codes along with the requested page of them. Codes are
+// drawn from the precomputed membership of stored value sets, or directly from the code system for implicit ones.
+func expandMembers(db *internal.DB, vs *valueSet, params expansionParams) (int64, []map[string]any, error) {
+	from, where, args := valueSetCodings(vs)
+	order := `"Coding".id`
+	if params.filter != "" {
+		query := textSearchQuery(params.filter)
+		if query == "" {
+			return 0, nil, nil
+		}
+		// Codes match when any of their designations match, ranked by the best matching one
+		args = append(args, query)
+		from += fmt.Sprintf(` JOIN (SELECT "Coding_Designation".coding, min("Coding_fts_idx".rank) AS rank
+			FROM "Coding_fts_idx" JOIN "Coding_Designation" ON "Coding_Designation".id = "Coding_fts_idx".rowid
+			WHERE "Coding_fts_idx" MATCH $%d GROUP BY "Coding_Designation".coding) "Match" ON "Match".coding = "Coding".id`, len(args))
+		order = `"Match".rank, "Coding".id`
+	}
+	if params.activeOnly {
+		where = append(where, "NOT "+codingInactiveSQL)
+	}
+	conditions := " WHERE " + strings.Join(where, " AND ")
+
+	results, err := db.Query(`SELECT count(*) AS total `+from+conditions, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	total := results[0]["total"].(int64)
+
+	args = append(args, internal.JSONArray(params.languages), params.count, params.offset)
+	results, err = db.Query(`SELECT "Coding".id, "CodeSystem".url AS system, json_extract(CAST("CodeSystem".json AS TEXT), '$.version') AS version,
+		"Coding".code, `+localizedDisplaySQL(len(args)-2)+` AS display, `+codingInactiveSQL+` AS inactive `+from+conditions+
+		fmt.Sprintf(` ORDER BY %s LIMIT $%d OFFSET $%d`, order, len(args)-1, len(args)), args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	contains := make([]map[string]any, 0, len(results))
+	for _, row := range results {
+		entry := formatContains(row)
+		if params.includeDesignations {
+			designations, err := findDesignations(db, &coding{id: row["id"].(int64)})
+			if err != nil {
+				return 0, nil, err
+			}
+			entry["designation"] = formatContainsDesignations(designations)
+		}
+		contains = append(contains, entry)
+	}
+	return total, contains, nil
+}
+
+func formatContains(row internal.Row) map[string]any {
+	entry := map[string]any{
+		"system": row["system"],
+		"code":   row["code"],
+	}
+	if version, ok := row["version"].(string); ok {
+		entry["version"] = version
+	}
+	if display, ok := row["display"].(string); ok {
+		entry["display"] = display
+	}
+	if row["inactive"] == int64(1) {
+		entry["inactive"] = true
+	}
+	return entry
+}
+
+func formatContainsDesignations(designations []designation) []map[string]any {
+	formatted := make([]map[string]any, 0, len(designations))
+	for _, d := range designations {
+		entry := map[string]any{"value": d.value}
+		if d.language != "" {
+			entry["language"] = d.language
+		}
+		if d.use != nil {
+			entry["use"] = d.use
+		}
+		formatted = append(formatted, entry)
+	}
+	return formatted
+}
+
+func formatExpansion(params expansionParams, total int64, contains []map[string]any) map[string]any {
+	parameters := []map[string]any{
+		{"name": "offset", "valueInteger": params.offset},
+		{"name": "count", "valueInteger": params.count},
+	}
+	if params.filter != "" {
+		parameters = append(parameters, map[string]any{"name": "filter", "valueString": params.filter})
+	}
+	if params.activeOnly {
+		parameters = append(parameters, map[string]any{"name": "activeOnly", "valueBoolean": true})
+	}
+	if params.includeDesignations {
+		parameters = append(parameters, map[string]any{"name": "includeDesignations", "valueBoolean": true})
+	}
+	if params.displayLanguage != "" {
+		parameters = append(parameters, map[string]any{"name": "displayLanguage", "valueCode": params.displayLanguage})
+	}
+
+	expansion := map[string]any{
+		"identifier": "urn:uuid:" + uuid.NewString(),
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		"total":      total,
+		"offset":     params.offset,
+		"parameter":  parameters,
+	}
+	if len(contains) > 0 {
+		expansion["contains"] = contains
+	}
+	return expansion
+}
+
+// Converts free text into an FTS5 query matching designations which contain every word, allowing each word to be
+// a prefix for type-ahead search. Returns an empty string if the text contains no searchable words.
+func textSearchQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, word := range words {
+		words[i] = `"` + word + `"*`
+	}
+	return strings.Join(words, " ")
+}
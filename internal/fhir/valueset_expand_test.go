@@ -0,0 +1,160 @@
+package fhir_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/mattwiller/hawthorn/internal/fhir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueSetExpandNotFound(t *testing.T) {
+	require := require.New(t)
+
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(err)
+	srv := fhir.ValueSetExpandHandler(db)
+
+	req := httptest.NewRequest("GET", "/R4/ValueSet/$expand?url=http://example.com/ValueSet/unknown", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(404, res.Result().StatusCode)
+	require.Equal("application/fhir+json; charset=utf-8", res.Result().Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(res.Result().Body)
+	require.NoError(err)
+	require.JSONEq(`{
+		"resourceType": "OperationOutcome",
+		"issue": [{"severity": "error", "code": "not-found", "details": {"text": "Value set not found"}}]
+	}`, string(body))
+}
+
+func TestValueSetExpandImplicit(t *testing.T) {
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(t, err)
+	srv := fhir.ValueSetExpandHandler(db)
+
+	tests := []struct {
+		name     string
+		url      string
+		expected string
+	}{
+		{
+			name:     "all codes with filter",
+			url:      "/R4/ValueSet/$expand?url=http://loinc.org/vs&filter=eye%20brain%20MRI",
+			expected: "79741-5",
+		},
+		{
+			name:     "prefix filter",
+			url:      "/R4/ValueSet/$expand?url=http://loinc.org/vs&filter=eye-rel%20bra",
+			expected: "79741-5",
+		},
+		{
+			name:     "is-a subtree",
+			url:      "/R4/ValueSet/$expand?url=http://snomed.info/sct?fhir_vs=isa/73211009&filter=type%202",
+			expected: "44054006",
+		},
+		{
+			name:     "synonym filter",
+			url:      "/R4/ValueSet/$expand?url=http://snomed.info/sct?fhir_vs&filter=heart%20attack",
+			expected: "22298006",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			req := httptest.NewRequest("GET", test.url, nil)
+			res := httptest.NewRecorder()
+			srv.ServeHTTP(res, req)
+
+			require.Equal(200, res.Result().StatusCode)
+
+			var vs struct {
+				ResourceType string `json:"resourceType"`
+				Expansion    struct {
+					Total    int `json:"total"`
+					Contains []struct {
+						Code string `json:"code"`
+					} `json:"contains"`
+				} `json:"expansion"`
+			}
+			require.NoError(json.NewDecoder(res.Result().Body).Decode(&vs))
+			require.Equal("ValueSet", vs.ResourceType)
+
+			var codes []string
+			for _, c := range vs.Expansion.Contains {
+				codes = append(codes, c.Code)
+			}
+			require.Contains(codes, test.expected)
+		})
+	}
+}
+
+func TestValueSetExpandDisplayLanguage(t *testing.T) {
+	require := require.New(t)
+
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(err)
+	srv := fhir.ValueSetExpandHandler(db)
+
+	req := httptest.NewRequest("GET", "/R4/ValueSet/$expand?url=http://snomed.info/sct?fhir_vs&filter=infarction&displayLanguage=es", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(200, res.Result().StatusCode)
+
+	var vs struct {
+		Expansion struct {
+			Contains []struct {
+				Code    string `json:"code"`
+				Display string `json:"display"`
+			} `json:"contains"`
+		} `json:"expansion"`
+	}
+	require.NoError(json.NewDecoder(res.Result().Body).Decode(&vs))
+	require.Len(vs.Expansion.Contains, 1)
+	require.Equal("22298006", vs.Expansion.Contains[0].Code)
+	require.Equal("infarto de miocardio", vs.Expansion.Contains[0].Display)
+}
+
+func TestValueSetExpandIncludeDesignations(t *testing.T) {
+	require := require.New(t)
+
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(err)
+	srv := fhir.ValueSetExpandHandler(db)
+
+	req := httptest.NewRequest("GET", "/R4/ValueSet/$expand?url=http://snomed.info/sct?fhir_vs&filter=heart%20attack&includeDesignations=true", nil)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+
+	require.Equal(200, res.Result().StatusCode)
+
+	var vs struct {
+		Expansion struct {
+			Contains []struct {
+				Code        string `json:"code"`
+				Designation []struct {
+					Language string `json:"language"`
+					Value    string `json:"value"`
+				} `json:"designation"`
+			} `json:"contains"`
+		} `json:"expansion"`
+	}
+	require.NoError(json.NewDecoder(res.Result().Body).Decode(&vs))
+	require.Len(vs.Expansion.Contains, 1)
+	require.Equal("22298006", vs.Expansion.Contains[0].Code)
+
+	var values []string
+	for _, d := range vs.Expansion.Contains[0].Designation {
+		values = append(values, d.Value)
+	}
+	require.Contains(values, "Heart attack")
+	require.Contains(values, "infarto de miocardio")
+}
@@ -0,0 +1,88 @@
+package fhir
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattwiller/hawthorn/internal"
+)
+
+// Implements the ValueSet/$validate-code operation endpoint.
+// @see http://hl7.org/fhir/R4/valueset-operation-validate-code.html
+func ValueSetValidateCodeHandler(db *internal.DB) http.HandlerFunc {
+	return handleOperation(db, func(db *internal.DB, w http.ResponseWriter, r *http.Request) {
+		input, err := readInput(r)
+		if err != nil {
+			sendError(w, "invalid", err.Error())
+			return
+		}
+
+		if !input.Has("url") {
+			sendError(w, "required", "Value set must be specified using the 'url' parameter")
+			return
+		}
+		url, version, _ := strings.Cut(input.Get("url"), "|")
+		if input.Has("valueSetVersion") {
+			version = input.Get("valueSetVersion")
+		}
+
+		codings := inputCodings(input, input.Get("system"), input.Get("systemVersion"))
+		if len(codings) == 0 {
+			sendError(w, "required", "Code must be specified using 'code' and 'system', 'coding', or 'codeableConcept' parameters")
+			return
+		}
+
+		vs, err := findValueSet(db, url, version)
+		if err == nil && vs == nil {
+			vs, err = findImplicitValueSet(db, url)
+		}
+		if err != nil {
+			sendError(w, "exception", "Error finding value set")
+			return
+		} else if vs == nil {
+			sendError(w, "not-found", "Value set not found")
+			return
+		}
+
+		var results []*validationResult
+		for _, c := range codings {
+			result, err := validateCoding(db, c)
+			if err != nil {
+				sendError(w, "exception", "Error validating code")
+				return
+			}
+
+			if result.coding != nil {
+				code := result.coding
+				if result.system.db != db {
+					// Value sets are only stored in the server's own database, so a code found in another release is
+					// checked using the same code in the default version of its code system
+					code, err = defaultCoding(db, c)
+				}
+				member := false
+				if err == nil && code != nil {
+					member, err = valueSetContains(db, vs, code)
+				}
+				if err != nil {
+					sendError(w, "exception", "Error validating code")
+					return
+				} else if !member {
+					result.fail(fmt.Sprintf("Code '%s' from code system '%s' is not in value set '%s'", c.Code, c.System, url))
+				}
+			}
+			results = append(results, result)
+		}
+
+		sendOutput(w, formatValidationResults(results))
+	})
+}
+
+// Finds a code in the default version of its code system, returning nil if the code or code system does not exist.
+func defaultCoding(db *internal.DB, c Coding) (*coding, error) {
+	system, err := findCodeSystem(db, c.System)
+	if err != nil || system == nil {
+		return nil, err
+	}
+	return findCoding(db, system, c.Code)
+}
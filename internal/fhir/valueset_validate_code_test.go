@@ -0,0 +1,85 @@
+package fhir_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/mattwiller/hawthorn/internal/fhir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueSetValidateCode(t *testing.T) {
+	db, err := internal.NewDB("../../umls.db")
+	require.NoError(t, err)
+	srv := fhir.ValueSetValidateCodeHandler(db)
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     string
+		expected string
+	}{
+		{
+			name:   "member of implicit value set",
+			method: "GET",
+			url:    "/R4/ValueSet/$validate-code?url=http://loinc.org/vs&system=http://loinc.org&code=79741-5",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": true},
+				{"name": "display", "valueString": "Eye-related brain MRI findings"}
+			]}`,
+		},
+		{
+			name:   "not a member",
+			method: "GET",
+			url:    "/R4/ValueSet/$validate-code?url=http://snomed.info/sct?fhir_vs&system=http://loinc.org&code=79741-5",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": false},
+				{"name": "message", "valueString": "Code '79741-5' from code system 'http://loinc.org' is not in value set 'http://snomed.info/sct?fhir_vs'"},
+				{"name": "display", "valueString": "Eye-related brain MRI findings"}
+			]}`,
+		},
+		{
+			name:   "not a member with incorrect display",
+			method: "GET",
+			url:    "/R4/ValueSet/$validate-code?url=http://snomed.info/sct?fhir_vs&system=http://loinc.org&code=79741-5&display=Brain%20MRI",
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": false},
+				{"name": "message", "valueString": "Display 'Brain MRI' is not valid for code '79741-5' in code system 'http://loinc.org', expected 'Eye-related brain MRI findings'; Code '79741-5' from code system 'http://loinc.org' is not in value set 'http://snomed.info/sct?fhir_vs'"},
+				{"name": "display", "valueString": "Eye-related brain MRI findings"}
+			]}`,
+		},
+		{
+			name:   "POST with coding",
+			method: "POST",
+			url:    "/R4/ValueSet/$validate-code",
+			body: `{"resourceType": "Parameters", "parameter": [
+				{"name": "url", "valueUri": "http://loinc.org/vs"},
+				{"name": "coding", "valueCoding": {"system": "http://loinc.org", "code": "79741-5"}}
+			]}`,
+			expected: `{"resourceType": "Parameters", "parameter": [
+				{"name": "result", "valueBoolean": true},
+				{"name": "display", "valueString": "Eye-related brain MRI findings"}
+			]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			res := httptest.NewRecorder()
+			srv.ServeHTTP(res, req)
+
+			require.Equal(200, res.Result().StatusCode)
+
+			body, err := io.ReadAll(res.Result().Body)
+			require.NoError(err)
+			require.JSONEq(test.expected, string(body))
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Description of the UMLS release, read from its release.dat file.
+type ReleaseInfo struct {
+	// Release name, e.g. 2023AB.
+	Name string `json:"name"`
+	// Description of the release, e.g. "Base Release for Fall 2023".
+	Description string `json:"description,omitempty"`
+	// Release date, in YYYYMMDD format.
+	Date string `json:"date,omitempty"`
+}
+
+// Parses a release.dat file, which contains properties of the form umls.release.name=2023AB.
+func ParseReleaseInfo(file io.Reader) (*ReleaseInfo, error) {
+	info := &ReleaseInfo{}
+	scan := bufio.NewScanner(file)
+	for scan.Scan() {
+		key, value, ok := strings.Cut(scan.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "umls.release.name":
+			info.Name = strings.TrimSpace(value)
+		case "umls.release.description":
+			info.Description = strings.TrimSpace(value)
+		case "umls.release.date":
+			info.Date = strings.TrimSpace(value)
+		}
+	}
+	return info, scan.Err()
+}
+
+// A version of a source vocabulary included in the UMLS release, from MRSAB.RRF.
+type SourceVersion struct {
+	// Root source abbreviation, e.g. SNOMEDCT_US.
+	RSAB string `json:"sab"`
+	// Versioned source abbreviation, e.g. SNOMEDCT_US_2023_09_01.
+	VSAB string `json:"versionedSab"`
+	// Official source name.
+	SON string `json:"name"`
+	// Source version, e.g. 2023_09_01.
+	SVER string `json:"version"`
+	// Language of the source.
+	LAT string `json:"language"`
+	// Whether this is the current version of the source in the release (Y or N).
+	CURVER string `json:"-"`
+}
+
+func ParseSourceVersion(row []byte) SourceVersion {
+	source := SourceVersion{}
+	fields := bytes.Split(row, pipeDelimiter)
+	for n, value := range fields {
+		switch n {
+		case 2:
+			source.VSAB = string(value)
+		case 3:
+			source.RSAB = string(value)
+		case 4:
+			source.SON = string(value)
+		case 6:
+			source.SVER = string(value)
+		case 19:
+			source.LAT = string(value)
+		case 21:
+			source.CURVER = string(value)
+		}
+	}
+	return source
+}
+
+// Reads the current versions of the loaded sources and their translations in the loaded languages, setting the version
+// of the CodeSystem for each loaded source to the one in the release, in the format FHIR uses for the code system.
+func LoadSourceVersions(db *DB, languages []string, file io.Reader) (map[string]SourceVersion, error) {
+	fmt.Println("Loading source versions:")
+	versions := make(map[string]SourceVersion, len(umlsSources))
+	scan := bufio.NewScanner(file)
+	for scan.Scan() {
+		source := ParseSourceVersion(scan.Bytes())
+		if source.CURVER != "Y" {
+			continue
+		} else if _, ok := translatedSource(source.RSAB); !ok {
+			continue
+		} else if source.LAT != "ENG" && !slices.Contains(languages, LanguageCode(source.LAT)) {
+			continue
+		}
+		versions[source.RSAB] = source
+
+		if loaded, ok := umlsSources[source.RSAB]; ok {
+			err := db.Prep(`UPDATE "CodeSystem" SET json = json_set(CAST(json AS TEXT), '$.version', $2) WHERE id = $1`,
+				loaded.resource.dbID, source.Version()).Exec()
+			if err != nil {
+				return nil, err
+			}
+		}
+		fmt.Printf("%s: %s\n", source.RSAB, source.SVER)
+	}
+	fmt.Println()
+	return versions, scan.Err()
+}
+
+// Returns the version of the source in the format used for its FHIR CodeSystem.version, which differs from the UMLS
+// source version for some code systems. Versions in an unknown format are returned unchanged.
+// @see https://terminology.hl7.org/codesystems.html
+func (source SourceVersion) Version() string {
+	if format, ok := versionFormats[source.RSAB]; ok {
+		if version, ok := format(source.SVER); ok {
+			return version
+		}
+	}
+	return source.SVER
+}
+
+// Converters from UMLS source versions to FHIR code system versions, keyed by SAB.
+var versionFormats = map[string]func(sver string) (string, bool){
+	// SNOMED CT versions are edition URIs including the release date, e.g. 2023_09_01 is
+	// http://snomed.info/sct/731000124108/version/20230901 for the US edition
+	// @see http://hl7.org/fhir/R4/snomedct.html#version
+	"SNOMEDCT_US": snomedVersion("731000124108"),
+	"SNOMEDCT":    snomedVersion("900000000000207008"),
+	// LOINC versions are dotted, e.g. 276 is 2.76
+	"LNC": func(sver string) (string, bool) {
+		if len(sver) < 3 || strings.Trim(sver, "0123456789") != "" {
+			return "", false
+		}
+		return sver[:len(sver)-2] + "." + sver[len(sver)-2:], true
+	},
+	// RxNorm versions are the release date in MMDDYYYY format, e.g. 23AB_230905F is 09052023
+	"RXNORM": func(sver string) (string, bool) {
+		_, release, ok := strings.Cut(sver, "_")
+		if !ok || len(release) < 6 {
+			return "", false
+		}
+		date, err := time.Parse("060102", release[:6])
+		if err != nil {
+			return "", false
+		}
+		return date.Format("01022006"), true
+	},
+	// CVX versions are the release date in YYYYMMDD format
+	"CVX": func(sver string) (string, bool) {
+		return strings.ReplaceAll(sver, "_", ""), true
+	},
+}
+
+func snomedVersion(module string) func(sver string) (string, bool) {
+	return func(sver string) (string, bool) {
+		date := strings.ReplaceAll(sver, "_", "")
+		if _, err := time.Parse("20060102", date); err != nil {
+			return "", false
+		}
+		return "http://snomed.info/sct/" + module + "/version/" + date, true
+	}
+}
+
+// Records a value in the build metadata, encoded as JSON.
+func SetMetadata(db *DB, key string, value any) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return db.Prep(`INSERT OR REPLACE INTO "Metadata" (key, value) VALUES ($1, $2)`, key, string(encoded)).Exec()
+}
+
+// Records the UMLS release and the sources loaded from it in the build metadata, including the term types and
+// languages the loaded designations were filtered to.
+func recordRelease(db *DB, cfg *BuildConfig, release *ReleaseInfo, versions map[string]SourceVersion) error {
+	type source struct {
+		SAB          string          `json:"sab"`
+		Url          string          `json:"url"`
+		Version      string          `json:"version,omitempty"`
+		Name         string          `json:"name,omitempty"`
+		TTY          []string        `json:"tty"`
+		Translations []SourceVersion `json:"translations,omitempty"`
+	}
+
+	sources := make([]*source, 0, len(umlsSources))
+	bySAB := make(map[string]*source, len(umlsSources))
+	for sab, loaded := range umlsSources {
+		s := &source{SAB: sab, Url: loaded.resource.Url, TTY: loaded.tty}
+		if version, ok := versions[sab]; ok {
+			s.Version = version.Version()
+			s.Name = version.SON
+		}
+		sources = append(sources, s)
+		bySAB[sab] = s
+	}
+	for sab, version := range versions {
+		if translated, _ := translatedSource(sab); translated != sab {
+			bySAB[translated].Translations = append(bySAB[translated].Translations, version)
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].SAB < sources[j].SAB })
+	for _, s := range sources {
+		sort.Slice(s.Translations, func(i, j int) bool { return s.Translations[i].RSAB < s.Translations[j].RSAB })
+	}
+
+	languages := append([]string{"en"}, cfg.Languages...)
+	metadata := map[string]any{"sources": sources, "languages": languages}
+	if release != nil {
+		metadata["umlsRelease"] = release
+	}
+	for key, value := range metadata {
+		if err := SetMetadata(db, key, value); err != nil {
+			return fmt.Errorf("error recording %s: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceVersion(t *testing.T) {
+	tests := []struct {
+		sab      string
+		sver     string
+		expected string
+	}{
+		{sab: "SNOMEDCT_US", sver: "2023_09_01", expected: "http://snomed.info/sct/731000124108/version/20230901"},
+		{sab: "SNOMEDCT", sver: "2023_08_01", expected: "http://snomed.info/sct/900000000000207008/version/20230801"},
+		{sab: "SNOMEDCT_US", sver: "unknown", expected: "unknown"},
+		{sab: "LNC", sver: "276", expected: "2.76"},
+		{sab: "LNC", sver: "2.76", expected: "2.76"},
+		{sab: "RXNORM", sver: "23AB_230905F", expected: "09052023"},
+		{sab: "CVX", sver: "2023_08_16", expected: "20230816"},
+		{sab: "ICD10CM", sver: "2024", expected: "2024"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sab+" "+test.sver, func(t *testing.T) {
+			source := internal.ParseSourceVersion([]byte("||" + test.sab + "_" + test.sver + "|" + test.sab + "||" + test.sab + "|" + test.sver + "|"))
+			require.Equal(t, test.sver, source.SVER)
+			require.Equal(t, test.expected, source.Version())
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The files of a UMLS release (e.g. MRCONSO.RRF), which can be read in any order regardless of how the release is
+// stored: as a zip archive, an extracted directory (such as a MetamorphoSys subset), or individual file paths.
+type Release interface {
+	// Opens the release file with the given name, returning an error wrapping fs.ErrNotExist if it is not included.
+	Open(name string) (io.ReadCloser, error)
+	Close() error
+}
+
+// Opens the UMLS release named by the config: its explicit files if any are given, or else the release directory or
+// zip archive.
+func OpenRelease(cfg *BuildConfig) (Release, error) {
+	if len(cfg.Files) > 0 {
+		files := make([]string, len(cfg.Files))
+		for i, file := range cfg.Files {
+			files[i] = cfg.Path(file)
+		}
+		return releaseFromFiles(files)
+	}
+
+	release := cfg.Path(cfg.Release)
+	info, err := os.Stat(release)
+	if err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		return releaseFromDirectory(release)
+	}
+	return releaseFromZip(release)
+}
+
+// A release stored as separate files, keyed by file name.
+type fileRelease map[string]string
+
+func releaseFromFiles(paths []string) (fileRelease, error) {
+	release := make(fileRelease, len(paths))
+	for _, p := range paths {
+		if err := release.add(p); err != nil {
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// Finds the release files anywhere within a directory.
+func releaseFromDirectory(dir string) (fileRelease, error) {
+	release := make(fileRelease, 16)
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !isReleaseFile(entry.Name()) {
+			return err
+		}
+		return release.add(p)
+	})
+	return release, err
+}
+
+// Whether a file is part of the release: the data files (*.RRF), and release.dat which describes the release.
+func isReleaseFile(name string) bool {
+	return strings.HasSuffix(name, ".RRF") || name == "release.dat"
+}
+
+func (release fileRelease) add(p string) error {
+	name := filepath.Base(p)
+	if existing, ok := release[name]; ok {
+		return fmt.Errorf("release contains multiple %s files: %s and %s", name, existing, p)
+	}
+	release[name] = p
+	return nil
+}
+
+func (release fileRelease) Open(name string) (io.ReadCloser, error) {
+	p, ok := release[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return os.Open(p)
+}
+
+func (release fileRelease) Close() error {
+	return nil
+}
+
+// A release stored in a zip archive, such as the full UMLS Metathesaurus release.
+type zipRelease struct {
+	archive *zip.ReadCloser
+	files   map[string]*zip.File
+}
+
+func releaseFromZip(file string) (*zipRelease, error) {
+	archive, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	release := &zipRelease{archive: archive, files: make(map[string]*zip.File, 16)}
+	for _, f := range archive.File {
+		name := path.Base(f.Name)
+		if f.FileInfo().IsDir() || !isReleaseFile(name) {
+			continue
+		} else if existing, ok := release.files[name]; ok {
+			archive.Close()
+			return nil, fmt.Errorf("release contains multiple %s files: %s and %s", name, existing.Name, f.Name)
+		}
+		release.files[name] = f
+	}
+	return release, nil
+}
+
+func (release *zipRelease) Open(name string) (io.ReadCloser, error) {
+	f, ok := release.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return f.Open()
+}
+
+func (release *zipRelease) Close() error {
+	return release.archive.Close()
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Writes a zip archive of the given files, keyed by their path in the archive, to a temporary directory.
+func writeZip(t *testing.T, files map[string]string) string {
+	require := require.New(t)
+
+	p := filepath.Join(t.TempDir(), "umls.zip")
+	file, err := os.Create(p)
+	require.NoError(err)
+	archive := zip.NewWriter(file)
+	for name, src := range files {
+		w, err := archive.Create(name)
+		require.NoError(err)
+		if src != "" {
+			contents, err := os.ReadFile(src)
+			require.NoError(err)
+			_, err = w.Write(contents)
+			require.NoError(err)
+		}
+	}
+	require.NoError(archive.Close())
+	require.NoError(file.Close())
+	return p
+}
+
+// Checks that the release contains the fixture MRCONSO.RRF and MRDOC.RRF, and not MRSAB.RRF.
+func requireReleaseFiles(t *testing.T, release Release) {
+	require := require.New(t)
+
+	for _, name := range []string{"MRCONSO.RRF", "MRDOC.RRF"} {
+		file, err := release.Open(name)
+		require.NoError(err)
+		contents, err := io.ReadAll(file)
+		require.NoError(err)
+		require.NoError(file.Close())
+		expected, err := os.ReadFile(filepath.Join("testdata", "release", name))
+		require.NoError(err)
+		require.Equal(string(expected), string(contents))
+	}
+	_, err := release.Open("MRSAB.RRF")
+	require.ErrorIs(err, fs.ErrNotExist)
+	require.ErrorContains(err, "MRSAB.RRF")
+}
+
+func TestOpenRelease(t *testing.T) {
+	zipFile := writeZip(t, map[string]string{
+		"2023AB/":                 "",
+		"2023AB/README.txt":       "testdata/build.yaml",
+		"2023AB/META/MRCONSO.RRF": "testdata/release/MRCONSO.RRF",
+		"2023AB/META/MRDOC.RRF":   "testdata/release/MRDOC.RRF",
+	})
+	tests := []struct {
+		name string
+		cfg  *BuildConfig
+	}{
+		{name: "directory", cfg: &BuildConfig{Release: "release", dir: "testdata"}},
+		{name: "zip", cfg: &BuildConfig{Release: zipFile, dir: "testdata"}},
+		{name: "files", cfg: &BuildConfig{Release: "missing.zip", Files: []string{"release/MRCONSO.RRF", "release/MRDOC.RRF"}, dir: "testdata"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			release, err := OpenRelease(test.cfg)
+			require.NoError(t, err)
+			defer release.Close()
+			requireReleaseFiles(t, release)
+		})
+	}
+}
+
+func TestOpenReleaseDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"META", "subset"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0o755))
+		contents, err := os.ReadFile("testdata/release/MRCONSO.RRF")
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, sub, "MRCONSO.RRF"), contents, 0o644))
+	}
+	zipFile := writeZip(t, map[string]string{
+		"2023AB/META/MRCONSO.RRF":   "testdata/release/MRCONSO.RRF",
+		"2023AB/subset/MRCONSO.RRF": "testdata/release/MRCONSO.RRF",
+	})
+	tests := []struct {
+		name string
+		cfg  *BuildConfig
+		err  string
+	}{
+		{
+			name: "directory",
+			cfg:  &BuildConfig{Release: dir},
+			err:  "release contains multiple MRCONSO.RRF files: " + filepath.Join(dir, "META", "MRCONSO.RRF") + " and " + filepath.Join(dir, "subset", "MRCONSO.RRF"),
+		},
+		{
+			name: "zip",
+			cfg:  &BuildConfig{Release: zipFile},
+			err:  "release contains multiple MRCONSO.RRF files: 2023AB/",
+		},
+		{
+			name: "files",
+			cfg:  &BuildConfig{Files: []string{"release/MRCONSO.RRF", "release/../release/MRCONSO.RRF"}, dir: "testdata"},
+			err:  "release contains multiple MRCONSO.RRF files: testdata/release/MRCONSO.RRF and testdata/release/MRCONSO.RRF",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := OpenRelease(test.cfg)
+			require.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestOpenReleaseMissing(t *testing.T) {
+	_, err := OpenRelease(&BuildConfig{Release: "missing.zip", dir: "testdata"})
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	release, err := OpenRelease(&BuildConfig{Files: []string{"missing/MRCONSO.RRF"}, dir: "testdata"})
+	require.NoError(t, err)
+	_, err = release.Open("MRCONSO.RRF")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,120 @@
+package internal
+
+// Statements creating the tables of the terminology database.
+var Schema = []string{
+	`CREATE TABLE IF NOT EXISTS "CodeSystem" (
+		id			INTEGER	PRIMARY KEY AUTOINCREMENT,
+		_id			TEXT	NOT NULL,
+		title		TEXT	NOT NULL,
+		url			TEXT	NOT NULL,
+		json		TEXT	NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS "ValueSet" (
+		id			INTEGER	PRIMARY KEY AUTOINCREMENT,
+		_id			TEXT	NOT NULL,
+		url			TEXT	NOT NULL,
+		json		TEXT	NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS "Coding" (
+		id			INTEGER	PRIMARY KEY AUTOINCREMENT,
+		system		INTEGER	NOT NULL, -- reference to "CodeSystem".id
+		code		TEXT				NOT NULL,
+		display		TEXT
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS "Coding_system_code_idx" ON "Coding" (system, code)`,
+
+	// Every string (UMLS atom) which designates a code, including its display.
+	`CREATE TABLE IF NOT EXISTS "Coding_Designation" (
+		id			INTEGER	PRIMARY KEY AUTOINCREMENT,
+		coding		INTEGER	NOT NULL, -- reference to "Coding".id
+		language	TEXT	NOT NULL,
+		use			TEXT	NOT NULL, -- UMLS term type (TTY)
+		value		TEXT	NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS "Coding_Designation_coding_idx" ON "Coding_Designation" (coding, language, use, value)`,
+	`CREATE VIRTUAL TABLE IF NOT EXISTS "Coding_fts_idx" USING fts5(value, tokenize = 'porter', content='Coding_Designation', content_rowid='id')`,
+
+	`CREATE TABLE IF NOT EXISTS "CodeSystem_Property" (
+		id			INTEGER	PRIMARY KEY AUTOINCREMENT,
+		system		INTEGER	NOT NULL,
+		code		TEXT	NOT NULL,
+		type		TEXT	NOT NULL,
+		uri			TEXT,
+		description TEXT
+	)`,
+
+	`CREATE TABLE IF NOT EXISTS "Coding_Property" (
+		coding		INTEGER	NOT NULL, -- reference to "Coding".id
+		property	INTEGER	NOT NULL, -- reference to "CodeSystem_Property".id
+		target		INTEGER, -- reference to "Coding".id, for relationship properties
+		value		TEXT -- value could be string | integer | boolean | dateTime
+	)`,
+
+	// Transitive closure of the is-a hierarchy, excluding the reflexive (self) relationship.
+	`CREATE TABLE IF NOT EXISTS "Coding_Closure" (
+		ancestor	INTEGER	NOT NULL, -- reference to "Coding".id
+		descendant	INTEGER	NOT NULL, -- reference to "Coding".id
+		PRIMARY KEY (ancestor, descendant)
+	) WITHOUT ROWID`,
+
+	// UMLS concepts (CUIs) of each code, which link synonymous codes across code systems.
+	`CREATE TABLE IF NOT EXISTS "Coding_CUI" (
+		cui			TEXT	NOT NULL,
+		coding		INTEGER	NOT NULL, -- reference to "Coding".id
+		PRIMARY KEY (cui, coding)
+	) WITHOUT ROWID`,
+
+	`CREATE TABLE IF NOT EXISTS "ConceptMap" (
+		id				INTEGER	PRIMARY KEY AUTOINCREMENT,
+		_id				TEXT	NOT NULL,
+		url				TEXT	NOT NULL,
+		json			TEXT	NOT NULL,
+		"sourceSystem"	INTEGER, -- reference to "CodeSystem".id
+		"targetSystem"	INTEGER -- reference to "CodeSystem".id
+	)`,
+	`CREATE TABLE IF NOT EXISTS "ConceptMap_Element" (
+		"conceptMap"	INTEGER	NOT NULL, -- reference to "ConceptMap".id
+		"sourceCode"	TEXT	NOT NULL,
+		source			INTEGER, -- reference to "Coding".id
+		"mapGroup"		INTEGER	NOT NULL,
+		priority		INTEGER	NOT NULL,
+		"targetCode"	TEXT, -- empty when the source code cannot be mapped
+		target			INTEGER, -- reference to "Coding".id
+		rule			TEXT,
+		advice			TEXT
+	)`,
+
+	// Description of the build: the UMLS release, source versions and filters, build time and Hawthorn commit.
+	`CREATE TABLE IF NOT EXISTS "Metadata" (
+		key			TEXT	PRIMARY KEY,
+		value		TEXT	NOT NULL -- JSON value
+	)`,
+
+	`CREATE TABLE IF NOT EXISTS "ValueSet_Membership" (
+		"valueSet"	INTEGER, -- reference to "ValueSet".id
+		coding		INTEGER, -- reference to "Coding".id
+		PRIMARY KEY ("valueSet", coding)
+	) WITHOUT ROWID`,
+}
+
+// Secondary indexes, and triggers to keep the FTS index up to date. In bulk mode, these are created once the release
+// files have been loaded rather than maintained for every inserted row.
+var Indexes = []string{
+	`CREATE INDEX IF NOT EXISTS "Coding_Property_idx" ON "Coding_Property" (coding, property)`,
+	`CREATE INDEX IF NOT EXISTS "Coding_Property_relationship_idx" ON "Coding_Property" (coding, target, property)
+		WHERE target IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS "Coding_Closure_descendant_idx" ON "Coding_Closure" (descendant, ancestor)`,
+	`CREATE INDEX IF NOT EXISTS "Coding_CUI_coding_idx" ON "Coding_CUI" (coding, cui)`,
+	`CREATE INDEX IF NOT EXISTS "ConceptMap_Element_source_idx" ON "ConceptMap_Element" (source, "conceptMap")`,
+	// Triggers to keep the FTS index up to date.
+	`CREATE TRIGGER IF NOT EXISTS "Coding_Designation_postinsert" AFTER INSERT ON "Coding_Designation" BEGIN
+		INSERT INTO "Coding_fts_idx" (rowid, value) VALUES (new.id, new.value);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS "Coding_Designation_postdelete" AFTER DELETE ON "Coding_Designation" BEGIN
+		INSERT INTO "Coding_fts_idx" ("Coding_fts_idx", rowid, value) VALUES ('delete', old.id, old.value);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS "Coding_Designation_postupdate" AFTER UPDATE ON "Coding_Designation" BEGIN
+		INSERT INTO "Coding_fts_idx" ("Coding_fts_idx", rowid, value) VALUES ('delete', old.id, old.value);
+		INSERT INTO "Coding_fts_idx" (rowid, value) VALUES (new.id, new.value);
+	END`,
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Creates an empty database with the full schema in a temporary directory, which is removed when the test ends.
+func newTestDB(t *testing.T) *DB {
+	require := require.New(t)
+
+	db, err := NewDB(filepath.Join(t.TempDir(), "umls.db"))
+	require.NoError(err)
+	t.Cleanup(func() { db.Close() })
+	for _, stmt := range append(Schema, Indexes...) {
+		_, err := db.Query(stmt)
+		require.NoError(err)
+	}
+	return db
+}
+
+// Builds a database from the small release in testdata, as configured by testdata/build.yaml.
+func loadTestRelease(t *testing.T) *DB {
+	require := require.New(t)
+
+	db := newTestDB(t)
+	cfg, err := ReadBuildConfig("testdata/build.yaml")
+	require.NoError(err)
+	require.NoError(LoadUMLS(db, cfg))
+	return db
+}
+
+// Returns the codes of each system matched by a query selecting "Coding".id, as system|code sorted by code.
+func queryCodes(t *testing.T, db *DB, query string, args ...any) []string {
+	var codes []string
+	err := db.Prep(`SELECT "CodeSystem".url || '|' || "Coding".code FROM "Coding"
+		JOIN "CodeSystem" ON "CodeSystem".id = "Coding".system
+		WHERE "Coding".id IN (`+query+`) ORDER BY "Coding".code`, args...).Each(func(rows *Rows) error {
+		var code string
+		codes = append(codes, code)
+		return rows.Scan(&codes[len(codes)-1])
+	})
+	require.NoError(t, err)
+	return codes
+}
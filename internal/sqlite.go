@@ -1,55 +1,365 @@
 package internal
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
 	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitex"
 )
 
+// A SQLite database, opened either as a single read-write connection for building the database, or as a pool of
+// read-only connections for serving concurrent requests.
 type DB struct {
+	pool *sqlitex.Pool
 	conn *sqlite.Conn
+	// Serializes use of a shared read-write connection; nil for connections acquired for a single request
+	mu      *sync.Mutex
+	release func()
+	// Databases of other releases attached to this one, which provide other versions of its code systems
+	releases []*DB
 }
 
+// Opens a single read-write connection to the database at path, creating it if necessary.
 func NewDB(path string) (*DB, error) {
 	conn, err := sqlite.OpenConn(path, sqlite.OpenCreate, sqlite.OpenReadWrite)
 	if err != nil {
 		return nil, err
 	}
+	if err := conn.CreateFunction("regexp", regexpFunction); err != nil {
+		conn.Close()
+		return nil, err
+	}
 
 	return &DB{
 		conn: conn,
+		mu:   new(sync.Mutex),
+	}, nil
+}
+
+// Opens a pool of size read-only connections to the existing database at path, sharing a single page cache.
+func OpenReadOnly(path string, size int) (*DB, error) {
+	pool, err := sqlitex.Open(path, sqlite.OpenReadOnly|sqlite.OpenSharedCache|sqlite.OpenNoMutex, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// All connections are opened up front, so they can each be set up before the pool is used
+	conns := make([]*sqlite.Conn, 0, size)
+	defer func() {
+		for _, conn := range conns {
+			pool.Put(conn)
+		}
+	}()
+	for i := 0; i < size; i++ {
+		conn := pool.Get(context.Background())
+		conns = append(conns, conn)
+		if err := conn.CreateFunction("regexp", regexpFunction); err != nil {
+			return nil, errors.Join(err, pool.Close())
+		}
+	}
+
+	return &DB{
+		pool: pool,
 	}, nil
 }
 
+// Acquires a connection for exclusive use until Release is called, e.g. for the duration of an HTTP request, along
+// with a connection to each attached release. Queries on the connections are interrupted when ctx is done.
+func (db *DB) Acquire(ctx context.Context) (*DB, error) {
+	var acquired *DB
+	if db.pool != nil {
+		conn := db.pool.Get(ctx)
+		if conn == nil {
+			return nil, fmt.Errorf("no database connection available: %w", context.Cause(ctx))
+		}
+		acquired = &DB{conn: conn, release: func() { db.pool.Put(conn) }}
+	} else if db.mu != nil {
+		db.mu.Lock()
+		acquired = &DB{conn: db.conn, release: db.mu.Unlock}
+	} else {
+		return db, nil
+	}
+
+	// Releases are always acquired after the database they are attached to, in order, so requests cannot deadlock
+	if len(db.releases) > 0 {
+		releaseConn := acquired.release
+		acquired.release = func() {
+			for _, conn := range acquired.releases {
+				conn.Release()
+			}
+			releaseConn()
+		}
+	}
+	for _, release := range db.releases {
+		conn, err := release.Acquire(ctx)
+		if err != nil {
+			acquired.Release()
+			return nil, err
+		}
+		acquired.releases = append(acquired.releases, conn)
+	}
+	return acquired, nil
+}
+
+// Attaches the databases of other releases (e.g. built from earlier UMLS releases), which serve other versions of the
+// code systems in this database. The attached databases are not closed along with this one.
+func (db *DB) AttachReleases(releases ...*DB) {
+	db.releases = append(db.releases, releases...)
+}
+
+// Returns the databases of other releases attached to this one, in the order they were attached. For a connection
+// obtained from Acquire, these are the connections to each release acquired along with it.
+func (db *DB) Releases() []*DB {
+	return db.releases
+}
+
+// Returns a connection obtained from Acquire.
+func (db *DB) Release() {
+	if db.release != nil {
+		db.release()
+		db.release = nil
+	}
+}
+
+// Runs a query, returning all result rows as maps of column name to value. Prefer Prep for queries with a fixed set of
+// result columns, which avoids allocating a map for each row. Like PrepTransient, the statement is not cached.
 func (db *DB) Query(query string, args ...any) ([]Row, error) {
 	var results []Row
-	err := sqlitex.Execute(db.conn, query, &sqlitex.ExecOptions{
-		Args: args,
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			results = append(results, ParseRow(stmt))
-			return nil
-		},
+	err := db.PrepTransient(query, args...).Each(func(rows *Rows) error {
+		results = append(results, ParseRow(rows.stmt))
+		return nil
 	})
 	return results, err
 }
 
+// Prepares a statement and binds its arguments, which are numbered from $1. Statements are cached by each connection,
+// so preparing the same query again only binds the new arguments. Any error is deferred until the result rows are
+// read, and the returned Rows must be closed (directly, or by First, Each, or Exec) to release the statement.
+func (db *DB) Prep(query string, args ...any) *Rows {
+	return db.prepare(query, false, args)
+}
+
+// Prepares a statement like Prep, but without caching it on the connection. Use it for SQL built dynamically (e.g.
+// from request parameters), which would otherwise grow the statement cache of long-lived connections without bound.
+func (db *DB) PrepTransient(query string, args ...any) *Rows {
+	return db.prepare(query, true, args)
+}
+
+func (db *DB) prepare(query string, transient bool, args []any) *Rows {
+	rows := &Rows{release: func() {}, transient: transient}
+	conn := db.conn
+	if db.pool != nil || db.mu != nil {
+		acquired, err := db.Acquire(context.Background())
+		if err != nil {
+			rows.err = err
+			return rows
+		}
+		conn, rows.release = acquired.conn, acquired.Release
+	}
+
+	if transient {
+		var trailing int
+		rows.stmt, trailing, rows.err = conn.PrepareTransient(query)
+		if rows.err == nil && trailing > 0 {
+			rows.err = fmt.Errorf("prepare %q: statement has trailing bytes", query)
+		}
+	} else {
+		rows.stmt, rows.err = conn.Prepare(query)
+	}
+	if rows.err == nil {
+		rows.err = bindArgs(rows.stmt, args)
+	}
+	if rows.err != nil {
+		rows.Close()
+	}
+	return rows
+}
+
+func bindArgs(stmt *sqlite.Stmt, args []any) error {
+	if len(args) > stmt.BindParamCount() {
+		return fmt.Errorf("too many query arguments: %d > %d", len(args), stmt.BindParamCount())
+	}
+	for param := 1; param <= stmt.BindParamCount(); param++ {
+		// Named parameters are indexed in order of first appearance, which may not match their number
+		i := param - 1
+		if name := stmt.BindParamName(param); strings.HasPrefix(name, "$") {
+			n, err := strconv.Atoi(name[1:])
+			if err != nil {
+				return fmt.Errorf("invalid query parameter %s", name)
+			}
+			i = n - 1
+		}
+		if i < 0 || i >= len(args) {
+			return fmt.Errorf("missing query argument for parameter %d", param)
+		}
+
+		switch arg := args[i].(type) {
+		case nil:
+			stmt.BindNull(param)
+		case int:
+			stmt.BindInt64(param, int64(arg))
+		case int64:
+			stmt.BindInt64(param, arg)
+		case float64:
+			stmt.BindFloat(param, arg)
+		case bool:
+			stmt.BindBool(param, arg)
+		case string:
+			stmt.BindText(param, arg)
+		case []byte:
+			stmt.BindBytes(param, arg)
+		default:
+			stmt.BindText(param, fmt.Sprint(arg))
+		}
+	}
+	return nil
+}
+
+// Encodes values as a JSON array, e.g. to bind as the argument of json_each in a query.
+func JSONArray(values []string) string {
+	output, err := json.Marshal(values)
+	if err != nil {
+		panic(err)
+	}
+	return string(output)
+}
+
 func (db *DB) Close() error {
+	if db.pool != nil {
+		return db.pool.Close()
+	}
 	return db.conn.Close()
 }
 
 func (db *DB) Batch() error {
-	return sqlitex.Execute(db.conn, "BEGIN", &sqlitex.ExecOptions{
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			return nil
-		},
-	})
+	return db.Prep("BEGIN").Exec()
 }
 
 func (db *DB) Flush() error {
-	return sqlitex.Execute(db.conn, "COMMIT", &sqlitex.ExecOptions{
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			return nil
-		},
-	})
+	return db.Prep("COMMIT").Exec()
+}
+
+// Discards the rows written since Batch, e.g. when an error occurs partway through writing them.
+func (db *DB) Rollback() error {
+	return db.Prep("ROLLBACK").Exec()
+}
+
+// The result rows of a prepared statement.
+type Rows struct {
+	stmt    *sqlite.Stmt
+	err     error
+	release func()
+	// Whether the statement is finalized when closed, rather than reset for reuse
+	transient bool
+}
+
+// Advances to the next result row, returning false when there are no more rows or an error occurred.
+func (rows *Rows) Next() bool {
+	if rows.stmt == nil {
+		return false
+	}
+	hasRow, err := rows.stmt.Step()
+	if err != nil || !hasRow {
+		rows.err = err
+		rows.Close()
+		return false
+	}
+	return true
+}
+
+// Copies the columns of the current row into the values pointed at by dest, in order. Supported destinations are
+// *int64, *int, *float64, *bool, *string, *[]byte, and *any; NULL values leave the destination unchanged.
+func (rows *Rows) Scan(dest ...any) error {
+	if len(dest) > rows.stmt.ColumnCount() {
+		return fmt.Errorf("too many scan destinations: %d > %d", len(dest), rows.stmt.ColumnCount())
+	}
+	for i, d := range dest {
+		if rows.stmt.ColumnType(i) == sqlite.TypeNull {
+			continue
+		}
+		switch d := d.(type) {
+		case *int64:
+			*d = rows.stmt.ColumnInt64(i)
+		case *int:
+			*d = rows.stmt.ColumnInt(i)
+		case *float64:
+			*d = rows.stmt.ColumnFloat(i)
+		case *bool:
+			*d = rows.stmt.ColumnBool(i)
+		case *string:
+			*d = rows.stmt.ColumnText(i)
+		case *[]byte:
+			*d = columnBytes(rows.stmt, i)
+		case *any:
+			*d = columnValue(rows.stmt, i)
+		default:
+			return fmt.Errorf("unsupported scan destination %T for column %s", d, rows.stmt.ColumnName(i))
+		}
+	}
+	return nil
+}
+
+// Returns the first error encountered while preparing or reading the result rows.
+func (rows *Rows) Err() error {
+	return rows.err
+}
+
+// Resets the statement for reuse (or finalizes a transient one) and releases its connection. It is safe to call Close
+// more than once.
+func (rows *Rows) Close() error {
+	if rows.stmt != nil {
+		if rows.transient {
+			if err := rows.stmt.Finalize(); err != nil && rows.err == nil {
+				rows.err = err
+			}
+		} else {
+			if err := rows.stmt.Reset(); err != nil && rows.err == nil {
+				rows.err = err
+			}
+			rows.stmt.ClearBindings()
+		}
+		rows.stmt = nil
+	}
+	if rows.release != nil {
+		rows.release()
+		rows.release = nil
+	}
+	return rows.err
+}
+
+// Calls fn for each result row, then closes the rows.
+func (rows *Rows) Each(fn func(rows *Rows) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Scans the first result row into dest and closes the rows, returning false if there were no results.
+func (rows *Rows) First(dest ...any) (bool, error) {
+	defer rows.Close()
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+	return true, rows.Scan(dest...)
+}
+
+// Runs a statement which is not expected to return any results, and closes the rows.
+func (rows *Rows) Exec() error {
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
 }
 
 type Row map[string]any
@@ -57,15 +367,54 @@ type Row map[string]any
 func ParseRow(stmt *sqlite.Stmt) Row {
 	row := make(map[string]any, stmt.ColumnCount())
 	for i := 0; i < stmt.ColumnCount(); i++ {
-		name := stmt.ColumnName(i)
-		switch stmt.ColumnType(i) {
-		case sqlite.TypeInteger:
-			row[name] = stmt.ColumnInt64(i)
-		case sqlite.TypeNull:
-			row[name] = nil
-		case sqlite.TypeText:
-			row[name] = stmt.ColumnText(i)
-		}
+		row[stmt.ColumnName(i)] = columnValue(stmt, i)
 	}
 	return row
 }
+
+func columnValue(stmt *sqlite.Stmt, i int) any {
+	switch stmt.ColumnType(i) {
+	case sqlite.TypeInteger:
+		return stmt.ColumnInt64(i)
+	case sqlite.TypeFloat:
+		return stmt.ColumnFloat(i)
+	case sqlite.TypeText:
+		return stmt.ColumnText(i)
+	case sqlite.TypeBlob:
+		return columnBytes(stmt, i)
+	}
+	return nil
+}
+
+func columnBytes(stmt *sqlite.Stmt, i int) []byte {
+	b := make([]byte, stmt.ColumnLen(i))
+	stmt.ColumnBytes(i, b)
+	return b
+}
+
+var compiledRegexps sync.Map
+
+// Implements the SQL `value REGEXP pattern` operator, which is not built into SQLite. The pattern must match the entire
+// value, as with FHIR value set filters.
+var regexpFunction = &sqlite.FunctionImpl{
+	NArgs:         2,
+	Deterministic: true,
+	Scalar: func(ctx sqlite.Context, args []sqlite.Value) (sqlite.Value, error) {
+		pattern := args[0].Text()
+		re, ok := compiledRegexps.Load(pattern)
+		if !ok {
+			compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return sqlite.Value{}, err
+			}
+			re, _ = compiledRegexps.LoadOrStore(pattern, compiled)
+		}
+
+		if args[1].Type() == sqlite.TypeNull {
+			return sqlite.IntegerValue(0), nil
+		} else if re.(*regexp.Regexp).MatchString(args[1].Text()) {
+			return sqlite.IntegerValue(1), nil
+		}
+		return sqlite.IntegerValue(0), nil
+	},
+}
@@ -0,0 +1,211 @@
+package internal_test
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/stretchr/testify/require"
+)
+
+// Opens a new database in a temporary directory, with a table of values of each column type.
+func newValuesDB(t testing.TB) *internal.DB {
+	require := require.New(t)
+
+	db, err := internal.NewDB(filepath.Join(t.TempDir(), "values.db"))
+	require.NoError(err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(db.Prep(`CREATE TABLE "Value" (id INTEGER PRIMARY KEY, n INTEGER, f REAL, s TEXT, b BLOB)`).Exec())
+	require.NoError(db.Prep(`INSERT INTO "Value" (id, n, f, s, b) VALUES (1, 42, 1.5, 'text', x'00ff'), (2, NULL, NULL, NULL, NULL)`).Exec())
+	return db
+}
+
+func TestRowsScan(t *testing.T) {
+	require := require.New(t)
+	db := newValuesDB(t)
+
+	var (
+		n       int64
+		i       int
+		f       float64
+		s       string
+		b       []byte
+		boolean bool
+		value   any
+	)
+	ok, err := db.Prep(`SELECT n, n, f, s, b, n > 0, f FROM "Value" WHERE id = $1`, 1).First(&n, &i, &f, &s, &b, &boolean, &value)
+	require.NoError(err)
+	require.True(ok)
+	require.Equal(int64(42), n)
+	require.Equal(42, i)
+	require.Equal(1.5, f)
+	require.Equal("text", s)
+	require.Equal([]byte{0, 0xff}, b)
+	require.True(boolean)
+	require.Equal(1.5, value)
+
+	// NULL values leave each destination unchanged
+	ok, err = db.Prep(`SELECT n, n, f, s, b, n > 0, b FROM "Value" WHERE id = $1`, 2).First(&n, &i, &f, &s, &b, &boolean, &value)
+	require.NoError(err)
+	require.True(ok)
+	require.Equal(int64(42), n)
+	require.Equal(42, i)
+	require.Equal(1.5, f)
+	require.Equal("text", s)
+	require.Equal([]byte{0, 0xff}, b)
+	require.True(boolean)
+	require.Equal(1.5, value)
+
+	ok, err = db.Prep(`SELECT n FROM "Value" WHERE id = $1`, 3).First(&n)
+	require.NoError(err)
+	require.False(ok)
+}
+
+func TestRowsScanError(t *testing.T) {
+	require := require.New(t)
+	db := newValuesDB(t)
+
+	var n int64
+	_, err := db.Prep(`SELECT n FROM "Value" WHERE id = 1`).First(&n, &n)
+	require.EqualError(err, "too many scan destinations: 2 > 1")
+	var u uint
+	_, err = db.Prep(`SELECT n FROM "Value" WHERE id = 1`).First(&u)
+	require.EqualError(err, "unsupported scan destination *uint for column n")
+}
+
+func TestRowsEach(t *testing.T) {
+	require := require.New(t)
+	db := newValuesDB(t)
+
+	// Parameters are bound by number, whatever order they appear in, and the cached statement is rebound each time
+	query := `SELECT id FROM "Value" WHERE id BETWEEN $2 AND $1 ORDER BY id`
+	for _, test := range []struct {
+		args     []any
+		expected []int64
+	}{
+		{args: []any{2, 1}, expected: []int64{1, 2}},
+		{args: []any{2, 2}, expected: []int64{2}},
+		{args: []any{0, 1}},
+	} {
+		var ids []int64
+		err := db.Prep(query, test.args...).Each(func(rows *internal.Rows) error {
+			var id int64
+			ids = append(ids, id)
+			return rows.Scan(&ids[len(ids)-1])
+		})
+		require.NoError(err)
+		require.Equal(test.expected, ids)
+	}
+}
+
+func TestPrepError(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		args  []any
+		err   string
+	}{
+		{name: "invalid query", query: `SELECT FROM "Value"`, err: "syntax error"},
+		{name: "too many arguments", query: `SELECT n FROM "Value" WHERE id = $1`, args: []any{1, 2}, err: "too many query arguments: 2 > 1"},
+		{name: "missing argument", query: `SELECT n FROM "Value" WHERE id = $2`, args: []any{1}, err: "missing query argument for parameter 1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newValuesDB(t)
+			rows := db.Prep(test.query, test.args...)
+			require.False(t, rows.Next())
+			require.ErrorContains(t, rows.Err(), test.err)
+			require.ErrorContains(t, rows.Close(), test.err)
+			require.ErrorContains(t, db.Prep(test.query, test.args...).Exec(), test.err)
+		})
+	}
+}
+
+func TestQueryColumnTypes(t *testing.T) {
+	require := require.New(t)
+	db := newValuesDB(t)
+
+	results, err := db.Query(`SELECT id, n, f, s, b FROM "Value" ORDER BY id`)
+	require.NoError(err)
+	require.Equal([]internal.Row{
+		{"id": int64(1), "n": int64(42), "f": 1.5, "s": "text", "b": []byte{0, 0xff}},
+		{"id": int64(2), "n": nil, "f": nil, "s": nil, "b": nil},
+	}, results)
+}
+
+func TestAcquireReleases(t *testing.T) {
+	require := require.New(t)
+
+	db, err := internal.OpenReadOnly("../umls.db", 2)
+	require.NoError(err)
+	defer db.Close()
+	release, err := internal.OpenReadOnly("../umls.db", 1)
+	require.NoError(err)
+	defer release.Close()
+	db.AttachReleases(release)
+
+	conn, err := db.Acquire(context.Background())
+	require.NoError(err)
+	require.Len(conn.Releases(), 1)
+	var count int64
+	_, err = conn.Releases()[0].Prep(`SELECT count(*) FROM "CodeSystem"`).First(&count)
+	require.NoError(err)
+	require.NotZero(count)
+
+	// The release's only connection is held for the whole request, so another request waits for it until its context
+	// is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = db.Acquire(ctx)
+	require.ErrorIs(err, context.DeadlineExceeded)
+
+	conn.Release()
+	conn, err = db.Acquire(context.Background())
+	require.NoError(err)
+	conn.Release()
+}
+
+func newBenchmarkDB(b *testing.B) *internal.DB {
+	db := newValuesDB(b)
+	require.NoError(b, db.Batch())
+	for i := 3; i <= 1000; i++ {
+		require.NoError(b, db.Prep(`INSERT INTO "Value" (id, n, s) VALUES ($1, $1, $2)`, i, strconv.Itoa(i)).Exec())
+	}
+	require.NoError(b, db.Flush())
+	return db
+}
+
+func BenchmarkQuery(b *testing.B) {
+	db := newBenchmarkDB(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		results, err := db.Query(`SELECT n, s FROM "Value" WHERE id = $1`, i%1000+1)
+		if err != nil {
+			b.Fatal(err)
+		} else if len(results) > 0 {
+			_, _ = results[0]["n"].(int64)
+		}
+	}
+}
+
+func BenchmarkPrep(b *testing.B) {
+	db := newBenchmarkDB(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var (
+			n int64
+			s string
+		)
+		if _, err := db.Prep(`SELECT n, s FROM "Value" WHERE id = $1`, i%1000+1).First(&n, &s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Package testdb prepares copies of the test database, which tests can modify to stand in for other releases.
+package testdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattwiller/hawthorn/internal"
+	"github.com/stretchr/testify/require"
+)
+
+// Statements which turn the test database into an earlier release of itself, in which LOINC is version 2.74, code
+// 2345-7 does not exist yet, and 79741-5 has a different display.
+var EarlierRelease = []string{
+	`UPDATE "CodeSystem" SET json = json_set(CAST(json AS TEXT), '$.version', '2.74') WHERE url = 'http://loinc.org'`,
+	`DELETE FROM "Coding" WHERE code = '2345-7'`,
+	`UPDATE "Coding" SET display = 'Brain MRI findings related to eye' WHERE code = '79741-5'`,
+}
+
+// Copies the database at path into a temporary directory as name, runs the given statements on the copy, and returns
+// the path of the copy. The copy is removed when the test ends.
+func Copy(t testing.TB, path string, name string, stmts ...string) string {
+	require := require.New(t)
+
+	contents, err := os.ReadFile(path)
+	require.NoError(err)
+	copyPath := filepath.Join(t.TempDir(), name)
+	require.NoError(os.WriteFile(copyPath, contents, 0o644))
+
+	db, err := internal.NewDB(copyPath)
+	require.NoError(err)
+	defer db.Close()
+	for _, stmt := range stmts {
+		_, err := db.Query(stmt)
+		require.NoError(err)
+	}
+	return copyPath
+}
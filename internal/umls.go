@@ -3,41 +3,20 @@ package internal
 import (
 	"bufio"
 	"bytes"
-	_ "embed"
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
+	"path"
 	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
-	"github.com/xenking/zipstream"
 )
 
-//go:embed resources/CodeSystem/snomed.json
-var snomed []byte
-
-//go:embed resources/CodeSystem/icd10pcs.json
-var icd10pcs []byte
-
-//go:embed resources/CodeSystem/icd10cm.json
-var icd10cm []byte
-
-//go:embed resources/CodeSystem/loinc.json
-var loinc []byte
-
-//go:embed resources/CodeSystem/rxnorm.json
-var rxnorm []byte
-
-//go:embed resources/CodeSystem/cpt.json
-var cpt []byte
-
-//go:embed resources/CodeSystem/cvx.json
-var cvx []byte
-
 type CodeSystem struct {
 	ResourceType     string               `json:"resourceType"`
 	Url              string               `json:"url"`
@@ -60,172 +39,168 @@ type CodeSystemProperty struct {
 }
 
 func ParseCodeSystem(bytes []byte) *CodeSystem {
-	var system CodeSystem
-	err := json.Unmarshal(bytes, &system)
+	system, err := parseCodeSystem(bytes)
 	if err != nil {
 		panic(err)
+	}
+	return system
+}
+
+func parseCodeSystem(bytes []byte) (*CodeSystem, error) {
+	var system CodeSystem
+	if err := json.Unmarshal(bytes, &system); err != nil {
+		return nil, err
 	} else if system.ResourceType != "CodeSystem" {
-		panic("Invalid resource type: " + system.ResourceType)
+		return nil, errors.New("Invalid resource type: " + system.ResourceType)
 	}
-	return &system
+	return &system, nil
 }
 
 func (system *CodeSystem) GetProperty(name string) *CodeSystemProperty {
-	for _, p := range system.Property {
-		if p.Code == name || p.Code == mappedProperties[name] {
-			return &p
+	return system.findProperty(func(p *CodeSystemProperty) bool {
+		return p.Code == name || p.Code == mappedProperties[name]
+	})
+}
+
+// Finds the first property of the code system matching the predicate, returning a pointer to the property itself so
+// that its database ID is shared by every row referring to it.
+func (system *CodeSystem) findProperty(match func(p *CodeSystemProperty) bool) *CodeSystemProperty {
+	for i := range system.Property {
+		if match(&system.Property[i]) {
+			return &system.Property[i]
 		}
 	}
 	return nil
 }
 
 type umlsSource struct {
-	systemID uuid.UUID
-	tty      []string
-	json     []byte
-	resource *CodeSystem
+	systemID     uuid.UUID
+	tty          []string
+	translations []string
+	json         []byte
+	resource     *CodeSystem
 }
 
-var umlsSources = map[string]umlsSource{
-	"SNOMEDCT_US": {
-		systemID: uuid.NewSHA1(uuid.NameSpaceURL, []byte("http://snomed.info/sct")),
-		tty:      []string{"FN", "PT", "SY"},
-		json:     snomed,
-		resource: ParseCodeSystem(snomed),
-	},
-	"ICD10PCS": {
-		systemID: uuid.NewSHA1(uuid.NameSpaceURL, []byte("http://hl7.org/fhir/sid/icd-10-pcs")),
-		tty:      []string{"PT", "HT"},
-		json:     icd10pcs,
-		resource: ParseCodeSystem(icd10pcs),
-	},
-	"ICD10CM": {
-		systemID: uuid.NewSHA1(uuid.NameSpaceURL, []byte("http://hl7.org/fhir/sid/icd-10-cm")),
-		tty:      []string{"PT", "HT"},
-		json:     icd10cm,
-		resource: ParseCodeSystem(icd10cm),
-	},
-	"LNC": {
-		systemID: uuid.NewSHA1(uuid.NameSpaceURL, []byte("http://loinc.org")),
-		tty:      []string{"LC", "LPDN", "LA", "DN", "HC", "LN", "LG"},
-		json:     loinc,
-		resource: ParseCodeSystem(loinc),
-	},
-	"CPT": {
-		systemID: uuid.NewSHA1(uuid.NameSpaceURL, []byte("http://www.ama-assn.org/go/cpt")),
-		tty:      []string{"PT", "HT", "POS", "MP", "GLP"},
-		json:     cpt,
-		resource: ParseCodeSystem(cpt),
-	},
-	"RXNORM": {
-		systemID: uuid.NewSHA1(uuid.NameSpaceURL, []byte("http://www.nlm.nih.gov/research/umls/rxnorm")),
-		tty:      []string{"PSN", "MIN", "SBD", "SCD", "SBDG", "SCDG", "GPCK", "SY"},
-		json:     rxnorm,
-		resource: ParseCodeSystem(rxnorm),
-	},
-	"CVX": {
-		systemID: uuid.NewSHA1(uuid.NameSpaceURL, []byte("http://hl7.org/fhir/sid/cvx")),
-		tty:      []string{"PT"},
-		json:     cvx,
-		resource: ParseCodeSystem(cvx),
-	},
-}
+// The UMLS sources being loaded, keyed by SAB, as configured by BuildConfig.
+var umlsSources = map[string]umlsSource{}
 
-func LoadUMLS(db *DB) error {
+// Loads the configured UMLS sources into the database, along with designations in the configured languages (e.g. "es")
+// in addition to English, which is always loaded since it provides the display of each code.
+func LoadUMLS(db *DB, cfg *BuildConfig) error {
 	fmt.Println("Loading UMLS...")
+	if err := cfg.configureSources(); err != nil {
+		return fmt.Errorf("error configuring sources: %w", err)
+	}
 	if err := LoadCodeSystems(db); err != nil {
 		return fmt.Errorf("error loading CodeSystems: %w", err)
 	}
 
-	archive, err := os.Open("umls-2023AB-full.zip")
+	release, err := OpenRelease(cfg)
+	if err != nil {
+		return fmt.Errorf("error opening UMLS release: %w", err)
+	}
+	defer release.Close()
+
+	// The release description and source versions are optional, since a subset of the release may not include them
+	var releaseInfo *ReleaseInfo
+	var versions map[string]SourceVersion
+	err = loadReleaseFile(release, "release.dat", true, func(file io.Reader) (err error) {
+		releaseInfo, err = ParseReleaseInfo(file)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("error opening UMLS data archive: %w", err)
+		return fmt.Errorf("error reading release description: %w", err)
 	}
-	unzip := zipstream.NewReader(archive)
+	err = loadReleaseFile(release, "MRSAB.RRF", true, func(file io.Reader) (err error) {
+		versions, err = LoadSourceVersions(db, cfg.Languages, file)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error loading source versions: %w", err)
+	}
+	if err := recordRelease(db, cfg, releaseInfo, versions); err != nil {
+		return fmt.Errorf("error recording build metadata: %w", err)
+	}
+
+	// Files are loaded in dependency order: properties and relationships refer to the loaded concepts, and relationship
+	// properties are named using the mappings documented in MRDOC.RRF
 	var concepts map[string]*Concept
 	var relationshipProperties map[string]string
-	completed := 0
-	for {
-		file, err := unzip.Next()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			fmt.Println(err.Error())
-			buf := make([]byte, 1)
-			_, err := unzip.Read(buf)
-			fmt.Println(err.Error())
-			// return fmt.Errorf("error reading zip file: %w", err)
-			continue
-		}
-
-		if strings.HasSuffix(file.Name, "/MRCONSO.RRF") {
-			if concepts, err = LoadConcepts(db, unzip); err != nil {
-				return fmt.Errorf("error loading concepts: %w", err)
-			}
-			completed++
-		} else if strings.HasSuffix(file.Name, "/MRDOC.RRF") {
-			relationshipProperties = MapProperties(unzip)
-			completed++
-		} else if strings.HasSuffix(file.Name, "/MRSAT.RRF") {
-			if concepts == nil {
-				return errors.New("expected to read concepts before properties (MRCONSO.RRF before MRSAT.RRF)")
-			}
-
-			if err := LoadProperties(db, concepts, unzip); err != nil {
-				return fmt.Errorf("error loading properties: %w", err)
-			}
-			completed++
-		} else if strings.HasSuffix(file.Name, "/MRREL.RRF") {
-			if concepts == nil {
-				return errors.New("expected to read concepts before relationship properties (MRCONSO.RRF before MRREL.RRF)")
-			} else if relationshipProperties == nil {
-				return errors.New("expected to read relationship mapping before relationship properties (MRDOC.RRF before MRREL.RRF)")
-			}
+	mapSets := make(MapSets)
+	err = loadReleaseFile(release, "MRCONSO.RRF", false, func(file io.Reader) (err error) {
+		concepts, err = LoadConcepts(db, cfg.Languages, cfg.batchSize(), file)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error loading concepts: %w", err)
+	}
+	err = loadReleaseFile(release, "MRDOC.RRF", false, func(file io.Reader) error {
+		relationshipProperties = MapProperties(file)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error loading relationship mappings: %w", err)
+	}
+	err = loadReleaseFile(release, "MRREL.RRF", false, func(file io.Reader) error {
+		return LoadRelationships(db, concepts, relationshipProperties, cfg.batchSize(), file)
+	})
+	if err != nil {
+		return fmt.Errorf("error loading relationships: %w", err)
+	}
+	err = loadReleaseFile(release, "MRSAT.RRF", false, func(file io.Reader) error {
+		return LoadProperties(db, concepts, mapSets, cfg.batchSize(), file)
+	})
+	if err != nil {
+		return fmt.Errorf("error loading properties: %w", err)
+	}
+	err = loadReleaseFile(release, "MRMAP.RRF", true, func(file io.Reader) error {
+		return LoadMappings(db, cfg.batchSize(), file)
+	})
+	if err != nil {
+		return fmt.Errorf("error loading mappings: %w", err)
+	}
 
-			if err := LoadRelationships(db, concepts, relationshipProperties, unzip); err != nil {
-				return fmt.Errorf("error loading relationships: %w", err)
-			}
-			completed++
-		} else {
-			fmt.Printf("skipping %s", file.Name)
-			if !file.FileInfo().IsDir() && file.UncompressedSize64 > 0 {
-				fmt.Print("..")
-				buf := make([]byte, 2^20)
-				var err error
-				for _, err = unzip.Read(buf); err == nil; _, err = unzip.Read(buf) {
-					continue
-				}
-				if err != io.EOF {
-					return fmt.Errorf("error reading skipped file %s: %w", file.Name, err)
-				}
-				fmt.Print("done\n")
-			} else {
-				fmt.Println()
-			}
-			continue
+	if cfg.AfterLoad != nil {
+		if err := cfg.AfterLoad(db); err != nil {
+			return err
 		}
+	}
 
-		if completed >= 4 {
-			break
-		}
+	if err := LoadClosure(db); err != nil {
+		return fmt.Errorf("error computing hierarchy closure: %w", err)
+	}
+	if err := LoadConceptMaps(db, mapSets); err != nil {
+		return fmt.Errorf("error loading concept maps: %w", err)
 	}
 	return nil
 }
 
+// Reads a file from the UMLS release, skipping it if it is optional and not included in the release.
+func loadReleaseFile(release Release, name string, optional bool, load func(file io.Reader) error) error {
+	file, err := release.Open(name)
+	if optional && errors.Is(err, fs.ErrNotExist) {
+		fmt.Printf("%s not included in release, skipping\n\n", name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	return load(file)
+}
+
 func LoadCodeSystems(db *DB) error {
 	fmt.Println("Loading code system definitions:")
 	for key, source := range umlsSources {
-		results, err := db.Query(
+		_, err := db.Prep(
 			`INSERT INTO "CodeSystem" (_id, title, url, json) VALUES ($1, $2, $3, $4) RETURNING id`,
 			source.systemID, source.resource.Title, source.resource.Url, source.json,
-		)
+		).First(&source.resource.dbID)
 		if err != nil {
 			fmt.Printf("%s ❌\n", key)
 			return err
 		}
 
 		fmt.Printf("%s ✅\n", key)
-		source.resource.dbID = results[0]["id"].(int64)
 	}
 
 	fmt.Println()
@@ -285,6 +260,8 @@ type Concept struct {
 	// ----- Private fields -----
 
 	dbID int64
+	// Whether the atom translates a code of another source, into a language other than English.
+	translation bool
 }
 
 var pipeDelimiter = []byte{'|'}
@@ -357,50 +334,81 @@ func ParseConcept(row []byte) Concept {
 	return concept
 }
 
-func LoadConcepts(db *DB, file io.Reader) (map[string]*Concept, error) {
-	scan := bufio.NewScanner(file)
+func LoadConcepts(db *DB, languages []string, batchSize int, file io.Reader) (map[string]*Concept, error) {
 	n := 0
 	codings := make(map[string]int, 8)
+	var translations []Concept
 
 	fmt.Println("Loading concepts:")
 	var concepts = make(map[string]*Concept, 2^20)
 	db.Batch()
-	for scan.Scan() {
-		line := scan.Bytes()
+	parse := func(line []byte) (Concept, bool) {
 		concept := ParseConcept(line)
+		if concept.SUPPRESS != "N" {
+			return concept, false
+		} else if concept.LAT != "ENG" && !slices.Contains(languages, LanguageCode(concept.LAT)) {
+			return concept, false
+		}
+
 		source, ok := umlsSources[concept.SAB]
-		if !ok || concept.LAT != "ENG" || concept.SUPPRESS != "N" {
-			continue
-		} else if !slices.Contains(source.tty, concept.TTY) {
-			continue
+		if !ok || concept.LAT != "ENG" {
+			sab, ok := translatedSource(concept.SAB)
+			concept.SAB = sab
+			concept.translation = true
+			return concept, ok
+		}
+		return concept, slices.Contains(source.tty, concept.TTY)
+	}
+	err := parseRows(file, parse, func(concept Concept) error {
+		if concept.translation {
+			// Translations are only stored as designations, once the code they translate has been loaded
+			translations = append(translations, concept)
+			return nil
 		}
 
+		source := umlsSources[concept.SAB]
 		key := concept.SAB + "|" + concept.CODE
-		if ex, exists := concepts[key]; exists {
-			if slices.Index(source.tty, concept.TTY) >= slices.Index(source.tty, ex.TTY) {
-				continue
+		ex, exists := concepts[key]
+		if !exists || slices.Index(source.tty, concept.TTY) < slices.Index(source.tty, ex.TTY) {
+			// The most preferred term type provides the display of the code
+			_, err := db.Prep(`INSERT INTO "Coding" (system, code, display) VALUES ($1, $2, $3) ON CONFLICT (system, code) DO UPDATE SET display = EXCLUDED.display RETURNING id`, source.resource.dbID, concept.CODE, concept.STR).
+				First(&concept.dbID)
+			if err != nil {
+				return err
 			}
+			if !exists {
+				codings[concept.SAB]++
+			}
+			concepts[concept.AUI] = &concept
+			concepts[key] = &concept
 		} else {
-			codings[concept.SAB]++
+			concept.dbID = ex.dbID
 		}
 
-		results, err := db.Query(`INSERT INTO "Coding" (system, code, display) VALUES ($1, $2, $3) ON CONFLICT (system, code) DO UPDATE SET display = EXCLUDED.display RETURNING id`, source.resource.dbID, concept.CODE, concept.STR)
-		if err != nil {
-			panic(err)
+		// Every atom links the code to its concept, and is a designation of the code
+		if err := insertCUI(db, concept.dbID, concept.CUI); err != nil {
+			return err
+		}
+		if err := insertDesignation(db, concept.dbID, &concept); err != nil {
+			return err
 		}
-
-		concept.dbID = results[0]["id"].(int64)
-		concepts[concept.AUI] = &concept
-		concepts[key] = &concept
 
 		n++
-		if n%500 == 0 {
+		if n%batchSize == 0 {
 			db.Flush()
 			fmt.Print(".")
 			db.Batch()
 		}
-	}
+		return nil
+	})
 	db.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadTranslations(db, concepts, translations); err != nil {
+		return nil, err
+	}
 
 	fmt.Println("✅")
 	fmt.Printf("Processed %d rows\n======================\n", n)
@@ -413,6 +421,93 @@ func LoadConcepts(db *DB, file io.Reader) (map[string]*Concept, error) {
 	return concepts, nil
 }
 
+// Finds the loaded source which a UMLS source is a translation of, such as the Spanish edition of SNOMED CT (SCTSPA)
+// or the LOINC linguistic variants (e.g. LNC-ES-MX). Non-English atoms of loaded sources are translations of it.
+func translatedSource(sab string) (string, bool) {
+	if _, ok := umlsSources[sab]; ok {
+		return sab, true
+	}
+	for source, config := range umlsSources {
+		for _, pattern := range config.translations {
+			if ok, _ := path.Match(pattern, sab); ok {
+				return source, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Stores translated designations of loaded codes. The designations of each code and language are stored in order of
+// the source's term type priority, so the first is the preferred display in that language.
+func loadTranslations(db *DB, concepts map[string]*Concept, translations []Concept) error {
+	priority := func(c Concept) int {
+		i := slices.Index(umlsSources[c.SAB].tty, c.TTY)
+		if i < 0 {
+			return len(umlsSources[c.SAB].tty)
+		}
+		return i
+	}
+	slices.SortStableFunc(translations, func(a, b Concept) int {
+		if c := cmp.Compare(a.SAB+"|"+a.CODE, b.SAB+"|"+b.CODE); c != 0 {
+			return c
+		} else if c := cmp.Compare(a.LAT, b.LAT); c != 0 {
+			return c
+		}
+		return cmp.Compare(priority(a), priority(b))
+	})
+
+	db.Batch()
+	defer db.Flush()
+	for _, translation := range translations {
+		coding, ok := concepts[translation.SAB+"|"+translation.CODE]
+		if !ok {
+			continue
+		}
+		if err := insertDesignation(db, coding.dbID, &translation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertCUI(db *DB, coding int64, cui string) error {
+	return db.Prep(`INSERT OR IGNORE INTO "Coding_CUI" (cui, coding) VALUES ($1, $2)`, cui, coding).Exec()
+}
+
+func insertDesignation(db *DB, coding int64, concept *Concept) error {
+	return db.Prep(`INSERT OR IGNORE INTO "Coding_Designation" (coding, language, use, value) VALUES ($1, $2, $3, $4)`,
+		coding, LanguageCode(concept.LAT), concept.TTY, concept.STR).Exec()
+}
+
+// Language codes (BCP-47) for the UMLS language abbreviations (LAT).
+// @see https://www.nlm.nih.gov/research/umls/knowledge_sources/metathesaurus/release/abbreviations.html#LAT
+var languageCodes = map[string]string{
+	"CHI": "zh",
+	"CZE": "cs",
+	"DAN": "da",
+	"DUT": "nl",
+	"ENG": "en",
+	"FRE": "fr",
+	"GER": "de",
+	"ITA": "it",
+	"JPN": "ja",
+	"KOR": "ko",
+	"NOR": "no",
+	"POL": "pl",
+	"POR": "pt",
+	"RUS": "ru",
+	"SPA": "es",
+	"SWE": "sv",
+}
+
+// Converts a UMLS language abbreviation to a language code, falling back to the lowercased abbreviation.
+func LanguageCode(lat string) string {
+	if code, ok := languageCodes[lat]; ok {
+		return code
+	}
+	return strings.ToLower(lat)
+}
+
 func MapProperties(file io.Reader) map[string]string {
 	scan := bufio.NewScanner(file)
 
@@ -538,39 +633,42 @@ var mappedProperties = map[string]string{
 	"LC":                "LONG_COMMON_NAME",
 }
 
-func LoadProperties(db *DB, concepts map[string]*Concept, file io.Reader) error {
-	scan := bufio.NewScanner(file)
+func LoadProperties(db *DB, concepts map[string]*Concept, mapSets MapSets, batchSize int, file io.Reader) error {
 	n := 0
 	propertyCounts := make(map[string]int, 64)
 
 	fmt.Println("Loading properties:")
 	db.Batch()
-	for scan.Scan() {
-		line := scan.Bytes()
+	parse := func(line []byte) (Attribute, bool) {
 		attribute := ParseAttribute(line)
-		source, ok := umlsSources[attribute.SAB]
-		if !ok || attribute.SUPPRESS != "N" {
-			continue
+		_, ok := umlsSources[attribute.SAB]
+		return attribute, ok && attribute.SUPPRESS == "N"
+	}
+	err := parseRows(file, parse, func(attribute Attribute) error {
+		source := umlsSources[attribute.SAB]
+		if slices.Contains(mapSetAttributes, attribute.ATN) {
+			mapSets.add(attribute)
+			return nil
 		}
 
 		property := source.resource.GetProperty(attribute.ATN)
 		if property == nil {
-			continue
+			return nil
 		}
 		if property.dbID == 0 {
-			results, err := db.Query(`INSERT INTO "CodeSystem_Property" (system, code, type, uri, description) VALUES ($1, $2, $3, $4, $5) RETURNING id`, source.resource.dbID, property.Code, property.Type, property.Uri, property.Description)
+			_, err := db.Prep(`INSERT INTO "CodeSystem_Property" (system, code, type, uri, description) VALUES ($1, $2, $3, $4, $5) RETURNING id`, source.resource.dbID, property.Code, property.Type, property.Uri, property.Description).
+				First(&property.dbID)
 			if err != nil {
 				return err
 			}
-			property.dbID = results[0]["id"].(int64)
 		}
 
 		concept := concepts[attribute.SAB+"|"+attribute.CODE]
 		if concept == nil {
-			panic("Unknown code: " + attribute.SAB + "|" + attribute.CODE)
+			return errors.New("Unknown code: " + attribute.SAB + "|" + attribute.CODE)
 		}
 
-		_, err := db.Query(`INSERT INTO "Coding_Property" (coding, property, value) VALUES ($1, $2, $3)`, concept.dbID, property.dbID, attribute.ATV)
+		err := db.Prep(`INSERT INTO "Coding_Property" (coding, property, value) VALUES ($1, $2, $3)`, concept.dbID, property.dbID, attribute.ATV).Exec()
 		if err != nil {
 			return err
 		}
@@ -578,13 +676,17 @@ func LoadProperties(db *DB, concepts map[string]*Concept, file io.Reader) error
 		propertyCounts[attribute.SAB+"|"+attribute.ATN]++
 		n++
 
-		if n%500 == 0 {
+		if n%batchSize == 0 {
 			db.Flush()
 			fmt.Print(".")
 			db.Batch()
 		}
-	}
+		return nil
+	})
 	db.Flush()
+	if err != nil {
+		return err
+	}
 
 	fmt.Println("✅")
 	for property, count := range propertyCounts {
@@ -682,69 +784,50 @@ func ParseRelationship(row []byte) Relationship {
 const PARENT_URI = "http://hl7.org/fhir/concept-properties#parent"
 const CHILD_URI = "http://hl7.org/fhir/concept-properties#child"
 
-func LoadRelationships(db *DB, concepts map[string]*Concept, relationshipProperties map[string]string, file io.Reader) error {
-	scan := bufio.NewScanner(file)
+func LoadRelationships(db *DB, concepts map[string]*Concept, relationshipProperties map[string]string, batchSize int, file io.Reader) error {
 	n := 0
 	propertyCounts := make(map[string]int, 64)
 
 	fmt.Println("Loading relationships:")
 	db.Batch()
-	for scan.Scan() {
-		line := scan.Bytes()
+	parse := func(line []byte) (Relationship, bool) {
 		relationship := ParseRelationship(line)
-		source, ok := umlsSources[relationship.SAB]
-		if !ok || relationship.SUPPRESS != "N" {
-			continue
-		}
-
+		_, ok := umlsSources[relationship.SAB]
+		return relationship, ok && relationship.SUPPRESS == "N"
+	}
+	err := parseRows(file, parse, func(relationship Relationship) error {
+		source := umlsSources[relationship.SAB]
 		mappedRelationshipProperty := relationshipProperties[relationship.SAB+"/"+relationship.REL+"/"+relationship.RELA]
-		var propertyName string
-		var property CodeSystemProperty
+		var property *CodeSystemProperty
 		if mappedRelationshipProperty != "" {
-			propertyName = mappedRelationshipProperty
-			for _, p := range source.resource.Property {
-				if p.Code == propertyName {
-					property = p
-					break
-				}
-			}
-		} else if relationship.REL == "PAR" {
-			for _, p := range source.resource.Property {
-				if p.Uri == PARENT_URI {
-					propertyName = p.Code
-					property = p
-					break
-				}
-			}
-		} else if relationship.REL == "CHD" {
-			for _, p := range source.resource.Property {
-				if p.Uri == CHILD_URI {
-					propertyName = p.Code
-					property = p
-					break
-				}
-			}
+			property = source.resource.findProperty(func(p *CodeSystemProperty) bool { return p.Code == mappedRelationshipProperty })
 		}
-		if propertyName == "" {
-			continue
+		// Hierarchical relationships without a property defined in the code system are stored as parent/child
+		if property == nil && relationship.REL == "PAR" {
+			property = source.resource.findProperty(func(p *CodeSystemProperty) bool { return p.Uri == PARENT_URI })
+		} else if property == nil && relationship.REL == "CHD" {
+			property = source.resource.findProperty(func(p *CodeSystemProperty) bool { return p.Uri == CHILD_URI })
+		}
+		if property == nil {
+			return nil
 		}
 		if property.dbID == 0 {
-			results, err := db.Query(`INSERT INTO "CodeSystem_Property" (system, code, type, uri, description) VALUES ($1, $2, $3, $4, $5) RETURNING id`, source.resource.dbID, property.Code, property.Type, property.Uri, property.Description)
+			_, err := db.Prep(`INSERT INTO "CodeSystem_Property" (system, code, type, uri, description) VALUES ($1, $2, $3, $4, $5) RETURNING id`, source.resource.dbID, property.Code, property.Type, property.Uri, property.Description).
+				First(&property.dbID)
 			if err != nil {
 				return err
 			}
-			property.dbID = results[0]["id"].(int64)
 		}
 
 		srcConcept := concepts[relationship.AUI1]
 		dstConcept := concepts[relationship.AUI2]
 		if srcConcept == nil || dstConcept == nil {
-			continue
+			return nil
 		}
 
-		key := fmt.Sprintf(`%s|%s (%s/%s)`, source.resource.Url, propertyName, relationship.REL, relationship.RELA)
+		key := fmt.Sprintf(`%s|%s (%s/%s)`, source.resource.Url, property.Code, relationship.REL, relationship.RELA)
 
-		_, err := db.Query(`INSERT INTO "Coding_Property" (coding, property, target, value) VALUES ($1, $2, $3, $4)`, srcConcept.dbID, property.dbID, dstConcept.dbID, dstConcept.CODE)
+		err := db.Prep(`INSERT INTO "Coding_Property" (coding, property, target, value) VALUES ($1, $2, $3, $4)`, srcConcept.dbID, property.dbID, dstConcept.dbID, dstConcept.CODE).Exec()
 		if err != nil {
 			return err
 		}
@@ -752,13 +835,17 @@ func LoadRelationships(db *DB, concepts map[string]*Concept, relationshipPropert
 		propertyCounts[key]++
 		n++
 
-		if n%500 == 0 {
+		if n%batchSize == 0 {
 			db.Flush()
 			fmt.Print(".")
 			db.Batch()
 		}
-	}
+		return nil
+	})
 	db.Flush()
+	if err != nil {
+		return err
+	}
 
 	fmt.Println("✅")
 	for property, count := range propertyCounts {
@@ -767,3 +854,46 @@ func LoadRelationships(db *DB, concepts map[string]*Concept, relationshipPropert
 	fmt.Printf("======================\n(total %d relationships)\n\n", n)
 	return nil
 }
+
+// Materializes the transitive closure of parent relationships for each code system with an is-a hierarchy, so that
+// subsumption and descendant queries can be answered with a single indexed lookup instead of walking the hierarchy.
+func LoadClosure(db *DB) error {
+	fmt.Println("Computing hierarchy closure:")
+	for key, source := range umlsSources {
+		if source.resource.HierarchyMeaning != "is-a" {
+			continue
+		}
+
+		db.Batch()
+		err := db.Prep(`INSERT OR IGNORE INTO "Coding_Closure" (ancestor, descendant)
+			WITH RECURSIVE "closure" (ancestor, descendant) AS (
+				SELECT "Code_Prop".target, "Code_Prop".coding FROM "Coding_Property" "Code_Prop"
+					JOIN "CodeSystem_Property" "Prop" ON "Prop".id = "Code_Prop".property
+					WHERE "Prop".system = $1 AND "Prop".uri = $2 AND "Code_Prop".target IS NOT NULL
+				UNION
+				SELECT "Code_Prop".target, "closure".descendant FROM "closure"
+					JOIN "Coding_Property" "Code_Prop" ON "Code_Prop".coding = "closure".ancestor
+					JOIN "CodeSystem_Property" "Prop" ON "Prop".id = "Code_Prop".property
+					WHERE "Prop".uri = $2 AND "Code_Prop".target IS NOT NULL
+			)
+			SELECT ancestor, descendant FROM "closure" WHERE ancestor != descendant`, source.resource.dbID, PARENT_URI).Exec()
+		if err != nil {
+			fmt.Printf("%s ❌\n", key)
+			return errors.Join(err, db.Rollback())
+		}
+		if err := db.Flush(); err != nil {
+			return err
+		}
+
+		var count int64
+		_, err = db.Prep(`SELECT count(*) FROM "Coding_Closure" JOIN "Coding" ON "Coding".id = "Coding_Closure".descendant
+			WHERE "Coding".system = $1`, source.resource.dbID).First(&count)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d ancestor/descendant pairs ✅\n", key, count)
+	}
+
+	fmt.Println()
+	return nil
+}
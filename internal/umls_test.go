@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Tables read and written by LoadClosure.
+var closureSetup = []string{
+	`CREATE TABLE "Coding" (id INTEGER PRIMARY KEY, system INTEGER NOT NULL, code TEXT NOT NULL)`,
+	`CREATE TABLE "CodeSystem_Property" (id INTEGER PRIMARY KEY, system INTEGER NOT NULL, uri TEXT)`,
+	`CREATE TABLE "Coding_Property" (coding INTEGER NOT NULL, property INTEGER NOT NULL, target INTEGER)`,
+	`CREATE TABLE "Coding_Closure" (
+		ancestor	INTEGER	NOT NULL,
+		descendant	INTEGER	NOT NULL,
+		PRIMARY KEY (ancestor, descendant)
+	) WITHOUT ROWID`,
+}
+
+func TestLoadClosure(t *testing.T) {
+	require := require.New(t)
+
+	db, err := NewDB(filepath.Join(t.TempDir(), "closure.db"))
+	require.NoError(err)
+	defer db.Close()
+	for _, stmt := range closureSetup {
+		_, err := db.Query(stmt)
+		require.NoError(err)
+	}
+
+	defer func(sources map[string]umlsSource) { umlsSources = sources }(umlsSources)
+	umlsSources = map[string]umlsSource{
+		"ISA":  {resource: &CodeSystem{HierarchyMeaning: "is-a", dbID: 1}},
+		"FLAT": {resource: &CodeSystem{dbID: 2}},
+	}
+
+	// Codes A-F are in the is-a system, and X-Y in a system without an is-a hierarchy
+	codes := map[string]int64{"A": 1, "B": 2, "C": 3, "D": 4, "E": 5, "F": 6, "X": 7, "Y": 8}
+	for code, id := range codes {
+		system := 1
+		if code >= "X" {
+			system = 2
+		}
+		_, err := db.Query(`INSERT INTO "Coding" (id, system, code) VALUES ($1, $2, $3)`, id, system, code)
+		require.NoError(err)
+	}
+	_, err = db.Query(`INSERT INTO "CodeSystem_Property" (id, system, uri) VALUES
+		(1, 1, $1), (2, 1, $2), (3, 2, $1)`, PARENT_URI, CHILD_URI)
+	require.NoError(err)
+	// C has two parents, A and B; E and F are each other's parent; child relationships are not followed
+	relationships := []struct {
+		code, target string
+		property     int
+	}{
+		{"B", "A", 1}, {"C", "A", 1}, {"C", "B", 1}, {"D", "C", 1}, {"E", "F", 1}, {"F", "E", 1}, {"A", "E", 2}, {"Y", "X", 3},
+	}
+	for _, r := range relationships {
+		_, err := db.Query(`INSERT INTO "Coding_Property" (coding, property, target) VALUES ($1, $2, $3)`,
+			codes[r.code], r.property, codes[r.target])
+		require.NoError(err)
+	}
+
+	require.NoError(LoadClosure(db))
+
+	rows, err := db.Query(`SELECT a.code || '>' || d.code AS pair FROM "Coding_Closure"
+		JOIN "Coding" a ON a.id = ancestor JOIN "Coding" d ON d.id = descendant ORDER BY pair`)
+	require.NoError(err)
+	var pairs []string
+	for _, row := range rows {
+		pairs = append(pairs, row["pair"].(string))
+	}
+	// Each ancestor appears once per descendant however many paths lead to it, and cycles add no self pairs
+	require.Equal([]string{"A>B", "A>C", "A>D", "B>C", "B>D", "C>D", "E>F", "F>E"}, pairs)
+}
@@ -0,0 +1,416 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type ValueSet struct {
+	ResourceType string             `json:"resourceType"`
+	ID           string             `json:"id"`
+	Url          string             `json:"url"`
+	Version      string             `json:"version"`
+	Compose      *ValueSetCompose   `json:"compose"`
+	Expansion    *ValueSetExpansion `json:"expansion"`
+
+	// ----- Private fields -----
+	raw      []byte
+	dbID     int64
+	computed bool
+}
+
+type ValueSetCompose struct {
+	Include []ValueSetInclude `json:"include"`
+	Exclude []ValueSetInclude `json:"exclude"`
+}
+
+type ValueSetInclude struct {
+	System  string `json:"system"`
+	Version string `json:"version"`
+	Concept []struct {
+		Code string `json:"code"`
+	} `json:"concept"`
+	Filter   []ValueSetFilter `json:"filter"`
+	ValueSet []string         `json:"valueSet"`
+}
+
+type ValueSetFilter struct {
+	Property string `json:"property"`
+	Op       string `json:"op"`
+	Value    string `json:"value"`
+}
+
+type ValueSetExpansion struct {
+	Contains []ValueSetContains `json:"contains"`
+}
+
+type ValueSetContains struct {
+	System   string             `json:"system"`
+	Code     string             `json:"code"`
+	Contains []ValueSetContains `json:"contains"`
+}
+
+// Loads FHIR ValueSet resources from a directory of JSON files, a single JSON file (either a ValueSet or a Bundle of
+// them), or an NDJSON file, and precomputes the membership of each value set from its compose rules.
+func LoadValueSets(db *DB, path string) error {
+	fmt.Println("Loading value sets:")
+	valueSets, err := ReadValueSets(path)
+	if err != nil {
+		return err
+	}
+
+	// Store all value sets before computing membership, since they may include each other. Each is loaded by its
+	// versioned URL, and by its plain URL for the last one stored, which is also the version served when none is given
+	loaded := make(map[string]*ValueSet, 2*len(valueSets))
+	db.Batch()
+	for _, vs := range valueSets {
+		id := vs.ID
+		if id == "" {
+			id = uuid.NewSHA1(uuid.NameSpaceURL, []byte(vs.key())).String()
+		}
+		_, err := db.Prep(`INSERT INTO "ValueSet" (_id, url, json) VALUES ($1, $2, $3) RETURNING id`, id, vs.Url, string(vs.raw)).
+			First(&vs.dbID)
+		if err != nil {
+			return errors.Join(err, db.Rollback())
+		}
+		loaded[vs.key()] = vs
+		loaded[vs.Url] = vs
+	}
+	if err := db.Flush(); err != nil {
+		return err
+	}
+
+	for _, vs := range valueSets {
+		if err := vs.computeMembership(db, loaded, nil); err != nil {
+			fmt.Printf("%s ❌ %s\n", vs.key(), err.Error())
+			return fmt.Errorf("error computing membership of value set %s: %w", vs.key(), err)
+		}
+
+		var count int64
+		if _, err := db.Prep(`SELECT count(*) FROM "ValueSet_Membership" WHERE "valueSet" = $1`, vs.dbID).First(&count); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d codes ✅\n", vs.key(), count)
+	}
+
+	fmt.Printf("======================\n(total %d value sets)\n\n", len(valueSets))
+	return nil
+}
+
+func ReadValueSets(path string) ([]*ValueSet, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return readValueSetFile(path)
+	}
+
+	var valueSets []*ValueSet
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if ext := filepath.Ext(file); ext != ".json" && ext != ".ndjson" {
+			return nil
+		}
+
+		resources, err := readValueSetFile(file)
+		if err != nil {
+			return err
+		}
+		valueSets = append(valueSets, resources...)
+		return nil
+	})
+	return valueSets, err
+}
+
+func readValueSetFile(path string) ([]*ValueSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var valueSets []*ValueSet
+	if filepath.Ext(path) == ".ndjson" {
+		scan := bufio.NewScanner(file)
+		scan.Buffer(make([]byte, 0, 1<<20), 1<<28)
+		for scan.Scan() {
+			line := bytes.TrimSpace(scan.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			resources, err := parseValueSets(bytes.Clone(line))
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s: %w", path, err)
+			}
+			valueSets = append(valueSets, resources...)
+		}
+		return valueSets, scan.Err()
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if valueSets, err = parseValueSets(contents); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return valueSets, nil
+}
+
+// Parses a ValueSet resource, or all ValueSet resources contained in a Bundle. Other resource types are ignored.
+func parseValueSets(raw []byte) ([]*ValueSet, error) {
+	var resource struct {
+		ResourceType string `json:"resourceType"`
+		Entry        []struct {
+			Resource json.RawMessage `json:"resource"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(raw, &resource); err != nil {
+		return nil, err
+	}
+
+	switch resource.ResourceType {
+	case "ValueSet":
+		var vs ValueSet
+		if err := json.Unmarshal(raw, &vs); err != nil {
+			return nil, err
+		} else if vs.Url == "" {
+			return nil, errors.New("ValueSet is missing url")
+		}
+		vs.raw = raw
+		return []*ValueSet{&vs}, nil
+	case "Bundle":
+		var valueSets []*ValueSet
+		for _, entry := range resource.Entry {
+			resources, err := parseValueSets(entry.Resource)
+			if err != nil {
+				return nil, err
+			}
+			valueSets = append(valueSets, resources...)
+		}
+		return valueSets, nil
+	default:
+		return nil, nil
+	}
+}
+
+// Identifies the value set by its URL and version, in the canonical url|version form.
+func (vs *ValueSet) key() string {
+	return vs.Url + "|" + vs.Version
+}
+
+// Evaluates the value set's compose rules against the loaded codes, and records the resulting membership. Value sets
+// included by this one are computed first; visiting tracks the chain of includes to detect cycles.
+func (vs *ValueSet) computeMembership(db *DB, loaded map[string]*ValueSet, visiting []string) error {
+	if vs.computed {
+		return nil
+	} else if slices.Contains(visiting, vs.key()) {
+		return fmt.Errorf("circular value set inclusion: %s", strings.Join(append(visiting, vs.key()), " -> "))
+	}
+	visiting = append(visiting, vs.key())
+
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	vsID := arg(vs.dbID)
+	var query string
+	if vs.Compose != nil {
+		if len(vs.Compose.Include) == 0 {
+			return errors.New("compose does not include any codes")
+		}
+		includes, err := vs.composeClauses(db, vs.Compose.Include, loaded, visiting, arg)
+		if err != nil {
+			return err
+		}
+		excludes, err := vs.composeClauses(db, vs.Compose.Exclude, loaded, visiting, arg)
+		if err != nil {
+			return err
+		}
+		if len(includes) == 0 {
+			// Every include is from a code system which is not loaded, so the value set has no members
+			vs.computed = true
+			return nil
+		}
+		query = strings.Join(includes, " UNION ")
+		if len(excludes) > 0 {
+			query += " EXCEPT " + strings.Join(excludes, " EXCEPT ")
+		}
+	} else if vs.Expansion != nil {
+		// Value sets distributed already expanded (e.g. from VSAC) list their codes directly
+		contains, err := json.Marshal(flattenContains(vs.Expansion.Contains))
+		if err != nil {
+			return err
+		}
+		query = `SELECT "Coding".id FROM json_each(` + arg(string(contains)) + `) "Entry"
+			JOIN "CodeSystem" ON "CodeSystem".url = json_extract("Entry".value, '$.system')
+			JOIN "Coding" ON "Coding".system = "CodeSystem".id AND "Coding".code = json_extract("Entry".value, '$.code')`
+	} else {
+		return errors.New("ValueSet has neither compose nor expansion")
+	}
+
+	db.Batch()
+	_, err := db.Query(`INSERT OR IGNORE INTO "ValueSet_Membership" ("valueSet", coding) SELECT `+vsID+`, id FROM (`+query+`)`, args...)
+	if err != nil {
+		return errors.Join(err, db.Rollback())
+	} else if err := db.Flush(); err != nil {
+		return err
+	}
+	vs.computed = true
+	return nil
+}
+
+func flattenContains(contains []ValueSetContains) []ValueSetContains {
+	var flattened []ValueSetContains
+	for _, c := range contains {
+		if c.Code != "" {
+			flattened = append(flattened, ValueSetContains{System: c.System, Code: c.Code})
+		}
+		flattened = append(flattened, flattenContains(c.Contains)...)
+	}
+	return flattened
+}
+
+// Builds a SQL query for each compose include or exclude rule. Rules for code systems which are not loaded are skipped
+// with a warning, since none of their codes can be members: value sets such as those from VSAC often also include codes
+// from systems like HCPCS, which should not stop the rest of the value set from being loaded.
+func (vs *ValueSet) composeClauses(db *DB, rules []ValueSetInclude, loaded map[string]*ValueSet, visiting []string, arg func(any) string) ([]string, error) {
+	clauses := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		clause, err := composeClause(db, rule, loaded, visiting, arg)
+		if errors.Is(err, errUnknownCodeSystem) {
+			fmt.Printf("%s ⚠️ skipped rule: %s\n", vs.key(), err.Error())
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+	return clauses, nil
+}
+
+var errUnknownCodeSystem = errors.New("unknown code system")
+
+// Builds a SQL query selecting the IDs of all "Coding" rows matched by a compose include or exclude rule.
+func composeClause(db *DB, include ValueSetInclude, loaded map[string]*ValueSet, visiting []string, arg func(any) string) (string, error) {
+	var conditions []string
+	if include.System != "" {
+		var systemID int64
+		found, err := db.Prep(`SELECT id FROM "CodeSystem" WHERE url = $1`, include.System).First(&systemID)
+		if err != nil {
+			return "", err
+		} else if !found {
+			return "", fmt.Errorf("%w %s", errUnknownCodeSystem, include.System)
+		}
+		conditions = append(conditions, `"Coding".system = `+arg(systemID))
+
+		if len(include.Concept) > 0 {
+			codes := make([]string, 0, len(include.Concept))
+			for _, concept := range include.Concept {
+				codes = append(codes, concept.Code)
+			}
+			conditions = append(conditions, `"Coding".code IN (SELECT value FROM json_each(`+arg(JSONArray(codes))+`))`)
+		}
+		for _, filter := range include.Filter {
+			condition, err := filterCondition(systemID, filter, arg)
+			if err != nil {
+				return "", err
+			}
+			conditions = append(conditions, condition)
+		}
+	} else if len(include.Concept) > 0 || len(include.Filter) > 0 {
+		return "", errors.New("concepts and filters require a code system")
+	}
+
+	for _, url := range include.ValueSet {
+		included := loaded[url]
+		if included == nil {
+			return "", fmt.Errorf("unknown value set %s", url)
+		} else if err := included.computeMembership(db, loaded, visiting); err != nil {
+			return "", err
+		}
+		conditions = append(conditions, `"Coding".id IN (SELECT coding FROM "ValueSet_Membership" WHERE "valueSet" = `+arg(included.dbID)+`)`)
+	}
+
+	if len(conditions) == 0 {
+		return "", errors.New("include must specify a code system or value set")
+	}
+	return `SELECT "Coding".id FROM "Coding" WHERE ` + strings.Join(conditions, " AND "), nil
+}
+
+// Converts a compose filter into a SQL condition on the "Coding" row.
+// @see http://hl7.org/fhir/R4/valueset-definitions.html#ValueSet.compose.include.filter
+func filterCondition(systemID int64, filter ValueSetFilter, arg func(any) string) (string, error) {
+	switch filter.Property {
+	case "concept", "code":
+		descendants := func() string {
+			return `(SELECT descendant FROM "Coding_Closure" WHERE ancestor IN (SELECT id FROM "Coding" "Target"
+				WHERE "Target".system = ` + arg(systemID) + ` AND "Target".code = ` + arg(filter.Value) + `))`
+		}
+		switch filter.Op {
+		case "=":
+			return `"Coding".code = ` + arg(filter.Value), nil
+		case "is-a":
+			return `("Coding".code = ` + arg(filter.Value) + ` OR "Coding".id IN ` + descendants() + `)`, nil
+		case "descendent-of", "descendant-of":
+			return `"Coding".id IN ` + descendants(), nil
+		case "is-not-a":
+			return `NOT ("Coding".code = ` + arg(filter.Value) + ` OR "Coding".id IN ` + descendants() + `)`, nil
+		case "in":
+			return `"Coding".code IN (SELECT value FROM json_each(` + arg(JSONArray(splitValues(filter.Value))) + `))`, nil
+		case "not-in":
+			return `"Coding".code NOT IN (SELECT value FROM json_each(` + arg(JSONArray(splitValues(filter.Value))) + `))`, nil
+		case "regex":
+			return `"Coding".code REGEXP ` + arg(filter.Value), nil
+		}
+	case "display":
+		switch filter.Op {
+		case "=":
+			return `"Coding".display = ` + arg(filter.Value), nil
+		case "regex":
+			return `"Coding".display REGEXP ` + arg(filter.Value), nil
+		}
+	default:
+		properties := `SELECT "Code_Prop".coding FROM "Coding_Property" "Code_Prop"
+			JOIN "CodeSystem_Property" "Prop" ON "Prop".id = "Code_Prop".property
+			WHERE "Prop".system = ` + arg(systemID) + ` AND "Prop".code = ` + arg(filter.Property)
+		switch filter.Op {
+		case "=":
+			return `"Coding".id IN (` + properties + ` AND "Code_Prop".value = ` + arg(filter.Value) + `)`, nil
+		case "in":
+			return `"Coding".id IN (` + properties + ` AND "Code_Prop".value IN (SELECT value FROM json_each(` + arg(JSONArray(splitValues(filter.Value))) + `)))`, nil
+		case "not-in":
+			return `"Coding".id NOT IN (` + properties + ` AND "Code_Prop".value IN (SELECT value FROM json_each(` + arg(JSONArray(splitValues(filter.Value))) + `)))`, nil
+		case "regex":
+			return `"Coding".id IN (` + properties + ` AND "Code_Prop".value REGEXP ` + arg(filter.Value) + `)`, nil
+		case "exists":
+			if filter.Value == "false" {
+				return `"Coding".id NOT IN (` + properties + `)`, nil
+			}
+			return `"Coding".id IN (` + properties + `)`, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported filter: %s %s %s", filter.Property, filter.Op, filter.Value)
+}
+
+func splitValues(value string) []string {
+	values := strings.Split(value, ",")
+	for i, v := range values {
+		values[i] = strings.TrimSpace(v)
+	}
+	return values
+}
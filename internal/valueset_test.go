@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadValueSets(t *testing.T) {
+	require := require.New(t)
+
+	db := loadTestRelease(t)
+	require.NoError(LoadValueSets(db, "testdata/valuesets"))
+
+	members := func(url string, version string) []string {
+		return queryCodes(t, db, `SELECT coding FROM "ValueSet_Membership" WHERE "valueSet" = (SELECT id FROM "ValueSet"
+			WHERE url = $1 AND coalesce(json_extract(CAST(json AS TEXT), '$.version'), '') = $2)`, url, version)
+	}
+	require.Equal([]string{"http://snomed.info/sct|44054006", "http://snomed.info/sct|46635009", "http://snomed.info/sct|73211009"},
+		members("http://example.com/vs/diabetes", "1"))
+	require.Equal([]string{"http://snomed.info/sct|44054006"}, members("http://example.com/vs/diabetes", "2"))
+	require.Equal([]string{"http://loinc.org|2339-0", "http://loinc.org|2345-7"}, members("http://example.com/vs/labs", ""))
+	// Includes version 1 of the diabetes value set, except type 1 diabetes
+	require.Equal([]string{"http://loinc.org|2339-0", "http://loinc.org|2345-7", "http://snomed.info/sct|44054006", "http://snomed.info/sct|73211009"},
+		members("http://example.com/vs/combined", ""))
+	// Includes the diabetes value set without a version, which is the last one loaded
+	require.Equal([]string{"http://snomed.info/sct|44054006"}, members("http://example.com/vs/latest", ""))
+	require.Equal([]string{"http://snomed.info/sct|22298006", "http://loinc.org|2345-7"}, members("http://example.com/vs/expanded", ""))
+	// Rules for code systems which are not loaded are skipped, leaving the codes from the rest
+	require.Equal([]string{"http://loinc.org|2345-7"}, members("http://example.com/vs/mixed", ""))
+	require.Empty(members("http://example.com/vs/hcpcs", ""))
+	var count int64
+	_, err := db.Prep(`SELECT count(*) FROM "ValueSet" WHERE url = $1`, "http://example.com/vs/hcpcs").First(&count)
+	require.NoError(err)
+	require.EqualValues(1, count)
+}
+
+func TestLoadValueSetsError(t *testing.T) {
+	tests := []struct {
+		name     string
+		valueSet string
+		err      string
+	}{
+		{
+			name:     "unsupported filter",
+			valueSet: `{"resourceType":"ValueSet","url":"http://example.com/vs/bad","compose":{"include":[{"system":"http://snomed.info/sct","filter":[{"property":"concept","op":"generalizes","value":"73211009"}]}]}}`,
+			err:      "error computing membership of value set http://example.com/vs/bad|: unsupported filter: concept generalizes 73211009",
+		},
+		{
+			name:     "unknown value set",
+			valueSet: `{"resourceType":"ValueSet","url":"http://example.com/vs/bad","version":"1","compose":{"include":[{"valueSet":["http://example.com/vs/diabetes|3"]}]}}`,
+			err:      "error computing membership of value set http://example.com/vs/bad|1: unknown value set http://example.com/vs/diabetes|3",
+		},
+		{
+			name:     "no includes",
+			valueSet: `{"resourceType":"ValueSet","url":"http://example.com/vs/bad","compose":{"exclude":[{"system":"http://loinc.org","concept":[{"code":"2345-7"}]}]}}`,
+			err:      "error computing membership of value set http://example.com/vs/bad|: compose does not include any codes",
+		},
+		{
+			name: "circular inclusion",
+			valueSet: `{"resourceType":"ValueSet","url":"http://example.com/vs/a","compose":{"include":[{"valueSet":["http://example.com/vs/b"]}]}}
+{"resourceType":"ValueSet","url":"http://example.com/vs/b","compose":{"include":[{"valueSet":["http://example.com/vs/a"]}]}}`,
+			err: "error computing membership of value set http://example.com/vs/a|: circular value set inclusion: http://example.com/vs/a| -> http://example.com/vs/b| -> http://example.com/vs/a|",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			path := filepath.Join(t.TempDir(), "valuesets.ndjson")
+			require.NoError(os.WriteFile(path, []byte(test.valueSet), 0o644))
+			db := loadTestRelease(t)
+			require.EqualError(LoadValueSets(db, path), test.err)
+		})
+	}
+}
+
+func TestFilterCondition(t *testing.T) {
+	db := loadTestRelease(t)
+
+	tests := []struct {
+		system   string
+		filter   ValueSetFilter
+		expected []string
+	}{
+		{
+			system:   "http://snomed.info/sct",
+			filter:   ValueSetFilter{Property: "concept", Op: "is-a", Value: "73211009"},
+			expected: []string{"44054006", "46635009", "73211009"},
+		},
+		{
+			system:   "http://snomed.info/sct",
+			filter:   ValueSetFilter{Property: "concept", Op: "descendent-of", Value: "73211009"},
+			expected: []string{"44054006", "46635009"},
+		},
+		{
+			system:   "http://snomed.info/sct",
+			filter:   ValueSetFilter{Property: "concept", Op: "is-not-a", Value: "64572001"},
+			expected: []string{"138875005"},
+		},
+		{
+			system:   "http://snomed.info/sct",
+			filter:   ValueSetFilter{Property: "concept", Op: "=", Value: "22298006"},
+			expected: []string{"22298006"},
+		},
+		{
+			system:   "http://snomed.info/sct",
+			filter:   ValueSetFilter{Property: "code", Op: "in", Value: "22298006, 44054006"},
+			expected: []string{"22298006", "44054006"},
+		},
+		{
+			system:   "http://loinc.org",
+			filter:   ValueSetFilter{Property: "code", Op: "regex", Value: "LP[0-9]+-[0-9]"},
+			expected: []string{"LP14635-4", "LP408570-2"},
+		},
+		{
+			system:   "http://loinc.org",
+			filter:   ValueSetFilter{Property: "display", Op: "regex", Value: "Glucose.*"},
+			expected: []string{"2339-0", "2345-7", "LP14635-4"},
+		},
+		{
+			system:   "http://loinc.org",
+			filter:   ValueSetFilter{Property: "STATUS", Op: "=", Value: "DEPRECATED"},
+			expected: []string{"2339-0"},
+		},
+		{
+			system:   "http://loinc.org",
+			filter:   ValueSetFilter{Property: "CLASS", Op: "exists", Value: "false"},
+			expected: []string{"LP14635-4", "LP408570-2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filter.Property+" "+test.filter.Op+" "+test.filter.Value, func(t *testing.T) {
+			require := require.New(t)
+
+			var systemID int64
+			_, err := db.Prep(`SELECT id FROM "CodeSystem" WHERE url = $1`, test.system).First(&systemID)
+			require.NoError(err)
+			var args []any
+			arg := func(value any) string {
+				args = append(args, value)
+				return "$" + strconv.Itoa(len(args))
+			}
+			condition, err := filterCondition(systemID, test.filter, arg)
+			require.NoError(err)
+
+			var expected []string
+			for _, code := range test.expected {
+				expected = append(expected, test.system+"|"+code)
+			}
+			query := `SELECT id FROM "Coding" WHERE system = ` + arg(systemID) + ` AND ` + condition
+			require.Equal(expected, queryCodes(t, db, query, args...))
+		})
+	}
+}
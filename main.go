@@ -1,23 +1,202 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mattwiller/hawthorn/internal"
 	"github.com/mattwiller/hawthorn/internal/fhir"
 )
 
-func main() {
-	db, err := internal.NewDB("umls.db")
+type config struct {
+	dbPath          string
+	releasePaths    []string
+	addr            string
+	basePath        string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+	maxHeaderBytes  int
+}
+
+// Reads the server configuration from command line flags, which default to the values of the corresponding
+// HAWTHORN_* environment variables when set. Invalid environment variables are only reported for flags which were not
+// given explicitly, so a flag can override them.
+func readConfig(args []string) (*config, error) {
+	env := envDefaults{}
+	cfg := &config{}
+	flags := flag.NewFlagSet("hawthorn", flag.ContinueOnError)
+	flags.StringVar(&cfg.dbPath, "db", env.string("db", "umls.db"), "Path to the terminology database `file` ($HAWTHORN_DB)")
+	releases := flags.String("releases", env.string("releases", ""), "Comma-separated database `files` of other releases, serving other code system versions ($HAWTHORN_RELEASES)")
+	flags.StringVar(&cfg.addr, "addr", env.string("addr", ":29927"), "`Address` to listen on, as host:port ($HAWTHORN_ADDR)")
+	flags.StringVar(&cfg.basePath, "base-path", env.string("base-path", "/R4"), "URL `path` prefix of the FHIR endpoints ($HAWTHORN_BASE_PATH)")
+	flags.DurationVar(&cfg.readTimeout, "read-timeout", env.duration("read-timeout", 10*time.Second), "Maximum `duration` for reading a request ($HAWTHORN_READ_TIMEOUT)")
+	flags.DurationVar(&cfg.writeTimeout, "write-timeout", env.duration("write-timeout", 30*time.Second), "Maximum `duration` for writing a response ($HAWTHORN_WRITE_TIMEOUT)")
+	flags.DurationVar(&cfg.idleTimeout, "idle-timeout", env.duration("idle-timeout", 2*time.Minute), "Maximum `duration` to keep an idle connection open ($HAWTHORN_IDLE_TIMEOUT)")
+	flags.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", env.duration("shutdown-timeout", 30*time.Second), "Maximum `duration` to wait for in-flight requests on shutdown ($HAWTHORN_SHUTDOWN_TIMEOUT)")
+	flags.IntVar(&cfg.maxHeaderBytes, "max-header-bytes", env.int("max-header-bytes", http.DefaultMaxHeaderBytes), "Maximum size of request headers in `bytes` ($HAWTHORN_MAX_HEADER_BYTES)")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	flags.Visit(func(f *flag.Flag) { delete(env, f.Name) })
+	if len(env) > 0 {
+		errs := make([]error, 0, len(env))
+		flags.VisitAll(func(f *flag.Flag) {
+			if err, ok := env[f.Name]; ok {
+				errs = append(errs, err)
+			}
+		})
+		return nil, errors.Join(errs...)
+	}
+
+	if *releases != "" {
+		cfg.releasePaths = strings.Split(*releases, ",")
+	}
+	cfg.basePath = strings.TrimSuffix(cfg.basePath, "/")
+	if cfg.basePath != "" && !strings.HasPrefix(cfg.basePath, "/") {
+		cfg.basePath = "/" + cfg.basePath
+	}
+	return cfg, nil
+}
+
+// Reads flag defaults from the environment variable for each flag, e.g. HAWTHORN_READ_TIMEOUT for -read-timeout.
+// Errors from parsing the variables are collected by flag name, so they can all be reported at once.
+type envDefaults map[string]error
+
+func envName(flag string) string {
+	return "HAWTHORN_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+func (env envDefaults) string(flag string, fallback string) string {
+	if value, ok := os.LookupEnv(envName(flag)); ok {
+		return value
+	}
+	return fallback
+}
+
+func (env envDefaults) duration(flag string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(envName(flag))
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
 	if err != nil {
-		panic(fmt.Errorf("error opening database file: %w", err))
+		env[flag] = fmt.Errorf("invalid %s: %w", envName(flag), err)
+		return fallback
 	}
+	return d
+}
 
-	http.HandleFunc("/R4/CodeSystem/$lookup", fhir.CodeSystemLookupHandler(db))
+func (env envDefaults) int(flag string, fallback int) int {
+	value, ok := os.LookupEnv(envName(flag))
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		env[flag] = fmt.Errorf("invalid %s: %w", envName(flag), err)
+		return fallback
+	}
+	return n
+}
+
+func routes(db *internal.DB, basePath string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_info", fhir.InfoHandler(db))
+	mux.HandleFunc(basePath+"/metadata", fhir.MetadataHandler(db, fhir.Operations))
+	for _, resourceType := range fhir.ResourceTypes {
+		mux.HandleFunc(basePath+"/"+resourceType, fhir.SearchHandler(db, resourceType))
+		mux.HandleFunc(basePath+"/"+resourceType+"/", fhir.ReadHandler(db, resourceType))
+	}
+	for _, op := range fhir.Operations {
+		mux.HandleFunc(basePath+op.Path(), op.Handler(db))
+	}
+	return mux
+}
+
+func run(cfg *config) error {
+	db, err := internal.OpenReadOnly(cfg.dbPath, runtime.GOMAXPROCS(0))
+	if err != nil {
+		return fmt.Errorf("error opening database file %s: %w", cfg.dbPath, err)
+	}
+	defer db.Close()
+	// The versions of each code system in the main database are the default, and other releases are searched in order
+	for _, path := range cfg.releasePaths {
+		release, err := internal.OpenReadOnly(path, runtime.GOMAXPROCS(0))
+		if err != nil {
+			return fmt.Errorf("error opening database file %s: %w", path, err)
+		}
+		defer release.Close()
+		db.AttachReleases(release)
+	}
+
+	server := &http.Server{
+		Addr:           cfg.addr,
+		Handler:        routes(db, cfg.basePath),
+		ReadTimeout:    cfg.readTimeout,
+		WriteTimeout:   cfg.writeTimeout,
+		IdleTimeout:    cfg.idleTimeout,
+		MaxHeaderBytes: cfg.maxHeaderBytes,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("Listening on %s\n", cfg.addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("error starting HTTP server: %w", err)
+	case <-ctx.Done():
+	}
+
+	// Stop accepting new connections, and wait for in-flight requests to finish before closing the database
+	fmt.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("error shutting down HTTP server: %w", err)
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		if err := runDiff(os.Args[2:]); errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg, err := readConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	fmt.Println("Listening on :29927")
-	if err := http.ListenAndServe(":29927", nil); err != nil {
-		panic(fmt.Errorf("error starting HTTP server: %w", err))
+	if err := run(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		expected config
+		err      string
+	}{
+		{
+			name: "defaults",
+			expected: config{dbPath: "umls.db", addr: ":29927", basePath: "/R4", readTimeout: 10 * time.Second,
+				writeTimeout: 30 * time.Second, idleTimeout: 2 * time.Minute, shutdownTimeout: 30 * time.Second, maxHeaderBytes: 1 << 20},
+		},
+		{
+			name: "environment overrides defaults",
+			env:  map[string]string{"HAWTHORN_DB": "env.db", "HAWTHORN_READ_TIMEOUT": "5s", "HAWTHORN_RELEASES": "a.db,b.db"},
+			expected: config{dbPath: "env.db", releasePaths: []string{"a.db", "b.db"}, addr: ":29927", basePath: "/R4", readTimeout: 5 * time.Second,
+				writeTimeout: 30 * time.Second, idleTimeout: 2 * time.Minute, shutdownTimeout: 30 * time.Second, maxHeaderBytes: 1 << 20},
+		},
+		{
+			name: "flags override environment",
+			env:  map[string]string{"HAWTHORN_DB": "env.db", "HAWTHORN_READ_TIMEOUT": "5s"},
+			args: []string{"-db", "flag.db", "-read-timeout", "1s", "-base-path", "fhir/"},
+			expected: config{dbPath: "flag.db", addr: ":29927", basePath: "/fhir", readTimeout: time.Second,
+				writeTimeout: 30 * time.Second, idleTimeout: 2 * time.Minute, shutdownTimeout: 30 * time.Second, maxHeaderBytes: 1 << 20},
+		},
+		{
+			name: "flag overrides invalid environment",
+			env:  map[string]string{"HAWTHORN_MAX_HEADER_BYTES": "lots"},
+			args: []string{"-max-header-bytes", "4096"},
+			expected: config{dbPath: "umls.db", addr: ":29927", basePath: "/R4", readTimeout: 10 * time.Second,
+				writeTimeout: 30 * time.Second, idleTimeout: 2 * time.Minute, shutdownTimeout: 30 * time.Second, maxHeaderBytes: 4096},
+		},
+		{
+			name: "invalid environment",
+			env:  map[string]string{"HAWTHORN_MAX_HEADER_BYTES": "lots", "HAWTHORN_IDLE_TIMEOUT": "forever"},
+			args: []string{"-db", "flag.db"},
+			err:  "invalid HAWTHORN_IDLE_TIMEOUT: time: invalid duration \"forever\"\ninvalid HAWTHORN_MAX_HEADER_BYTES: strconv.Atoi: parsing \"lots\": invalid syntax",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			cfg, err := readConfig(test.args)
+			if test.err != "" {
+				require.EqualError(err, test.err)
+				return
+			}
+			require.NoError(err)
+			require.Equal(test.expected, *cfg)
+		})
+	}
+}
+
+func TestReadConfigHelp(t *testing.T) {
+	require := require.New(t)
+	t.Setenv("HAWTHORN_READ_TIMEOUT", "soon")
+
+	_, err := readConfig([]string{"-h"})
+	require.ErrorIs(err, flag.ErrHelp)
+}